@@ -784,10 +784,11 @@ func TruncateTd(tx kv.RwTx, blockFrom uint64) error {
 	return nil
 }
 
-// ReadRawReceipts retrieves all the transaction receipts belonging to a block.
+// ReadRawReceipts retrieves all the transaction receipts belonging to a block,
+// decoding their logs with codec. Logs are stored in the format of types.CBORLogCodec.
 // The receipt metadata fields are not guaranteed to be populated, so they
 // should not be used. Use ReadReceipts instead if the metadata is needed.
-func ReadRawReceipts(db kv.Tx, blockNum uint64) types.Receipts {
+func ReadRawReceipts(db kv.Tx, blockNum uint64, codec types.LogCodec) types.Receipts {
 	// Retrieve the flattened receipt slice
 	data, err := db.GetOne(kv.Receipts, hexutility.EncodeTs(blockNum))
 	if err != nil {
@@ -817,8 +818,8 @@ func ReadRawReceipts(db kv.Tx, blockNum uint64) types.Receipts {
 			log.Error("logs fetching failed", "err", err)
 			return nil
 		}
-		var logs types.Logs
-		if err := cbor.Unmarshal(&logs, bytes.NewReader(v)); err != nil {
+		logs, err := types.DecodeStoredLogs(codec, v)
+		if err != nil {
 			err = fmt.Errorf("receipt unmarshal failed:  %w", err)
 			log.Error("logs fetching failed", "err", err)
 			return nil
@@ -835,26 +836,26 @@ func ReadRawReceipts(db kv.Tx, blockNum uint64) types.Receipts {
 	return receipts
 }
 
-// WriteReceipts stores all the transaction receipts belonging to a block.
-func WriteReceipts(tx kv.Putter, number uint64, receipts types.Receipts) error {
-	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+// WriteReceipts stores all the transaction receipts belonging to a block, encoding
+// their logs with codec. types.CBORLogCodec writes the format of the kv.Log table,
+// other codecs are only meant for experimental databases.
+func WriteReceipts(tx kv.Putter, number uint64, receipts types.Receipts, codec types.LogCodec) error {
 	for txId, r := range receipts {
 		if len(r.Logs) == 0 {
 			continue
 		}
 
-		buf.Reset()
-		err := cbor.Marshal(buf, r.Logs)
+		logs, err := types.EncodeStoredLogs(codec, r.Logs)
 		if err != nil {
 			return fmt.Errorf("encode block logs for block %d: %w", number, err)
 		}
 
-		if err = tx.Put(kv.Log, dbutils.LogKey(number, uint32(txId)), buf.Bytes()); err != nil {
+		if err = tx.Put(kv.Log, dbutils.LogKey(number, uint32(txId)), logs); err != nil {
 			return fmt.Errorf("writing logs for block %d: %w", number, err)
 		}
 	}
 
-	buf.Reset()
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
 	err := cbor.Marshal(buf, receipts)
 	if err != nil {
 		return fmt.Errorf("encode block receipts for block %d: %w", number, err)
@@ -33,11 +33,14 @@ import (
 	libcommon "github.com/erigontech/erigon-lib/common"
 	"github.com/erigontech/erigon-lib/common/u256"
 	"github.com/erigontech/erigon-lib/crypto"
+	"github.com/erigontech/erigon-lib/kv"
+	"github.com/erigontech/erigon-lib/kv/dbutils"
 	"github.com/erigontech/erigon-lib/kv/memdb"
 	"github.com/erigontech/erigon-lib/log/v3"
 	"github.com/erigontech/erigon-lib/rlp"
 	"github.com/erigontech/erigon/core/rawdb"
 	"github.com/erigontech/erigon/core/types"
+	"github.com/erigontech/erigon/ethdb/cbor"
 	"github.com/erigontech/erigon/params"
 	"github.com/erigontech/erigon/turbo/stages/mock"
 )
@@ -408,6 +411,42 @@ func TestCanonicalMappingStorage(t *testing.T) {
 	}
 }
 
+// Tests receipt storage, with logs encoded through a LogCodec.
+func TestReceiptStorage(t *testing.T) {
+	t.Parallel()
+	_, tx := memdb.NewTestTx(t)
+
+	logs := types.Logs{
+		{Address: libcommon.Address{1}, Topics: []libcommon.Hash{{2}, {3}}, Data: []byte{4, 5}},
+		{Address: libcommon.Address{6}, Topics: []libcommon.Hash{}, Data: []byte{}},
+	}
+	receipts := types.Receipts{
+		{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: 21000, Logs: logs},
+		{Status: types.ReceiptStatusFailed, CumulativeGasUsed: 42000},
+	}
+	codec := types.CBORLogCodec{}
+	require.NoError(t, rawdb.WriteReceipts(tx, 7, receipts, codec))
+
+	// logs keep the CBOR format of the table
+	var want bytes.Buffer
+	require.NoError(t, cbor.Marshal(&want, logs))
+	stored, err := tx.GetOne(kv.Log, dbutils.LogKey(7, 0))
+	require.NoError(t, err)
+	require.Equal(t, want.Bytes(), stored)
+
+	got := rawdb.ReadRawReceipts(tx, 7, codec)
+	require.Len(t, got, 2)
+	require.Equal(t, uint64(21000), got[0].CumulativeGasUsed)
+	require.Len(t, got[0].Logs, len(logs))
+	for i, l := range got[0].Logs {
+		require.Equal(t, logs[i].Address, l.Address)
+		require.Equal(t, logs[i].Topics, l.Topics)
+		require.Equal(t, logs[i].Data, l.Data)
+	}
+	require.Empty(t, got[1].Logs)
+	require.Nil(t, rawdb.ReadRawReceipts(tx, 8, codec))
+}
+
 // Tests that head headers and head blocks can be assigned, individually.
 func TestHeadStorage2(t *testing.T) {
 	t.Parallel()
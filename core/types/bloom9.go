@@ -85,6 +85,16 @@ func (b *Bloom) add(d []byte, buf []byte) {
 	b[i3] |= v3
 }
 
+// AddLog adds the address and topics of l to the filter, so a bloom can be built
+// incrementally as logs are appended.
+func (b *Bloom) AddLog(l *Log) {
+	buf := make([]byte, 6)
+	b.add(l.Address.Bytes(), buf)
+	for _, topic := range l.Topics {
+		b.add(topic[:], buf)
+	}
+}
+
 // Big converts b to a big integer.
 // Note: Converting a bloom filter to a big.Int and then calling GetBytes
 // does not return the same bytes, since big.Int will trim leading zeroes
@@ -142,6 +152,37 @@ func LogsBloom(logs []*Log) []byte {
 	return bin[:]
 }
 
+// ComputeLogsBloom returns the consensus logsBloom of a block with the given logs, as
+// found in its header: the address and every topic of each log are hashed with
+// keccak256 and each sets the three bits selected by the low 11 bits of the first
+// three byte pairs of its hash.
+func ComputeLogsBloom(logs Logs) [BloomByteLength]byte {
+	var bin Bloom
+	for _, l := range logs {
+		bin.AddLog(l)
+	}
+	return bin
+}
+
+// LogInRawBloom reports whether the address and all topics of l are set in a raw
+// header logsBloom. Like any bloom test it may report false positives.
+func LogInRawBloom(l *Log, bloom [BloomByteLength]byte) bool {
+	buf := make([]byte, 6)
+	in := func(d []byte) bool {
+		i1, v1, i2, v2, i3, v3 := bloomValues(d, buf)
+		return bloom[i1]&v1 == v1 && bloom[i2]&v2 == v2 && bloom[i3]&v3 == v3
+	}
+	if !in(l.Address[:]) {
+		return false
+	}
+	for _, topic := range l.Topics {
+		if !in(topic[:]) {
+			return false
+		}
+	}
+	return true
+}
+
 // Bloom9 returns the bloom filter for the given data
 func Bloom9(data []byte) []byte {
 	var b Bloom
@@ -155,6 +196,11 @@ func bloomValues(data []byte, hashbuf []byte) (uint, byte, uint, byte, uint, byt
 	sha.Write(data)   //nolint:errcheck
 	sha.Read(hashbuf) //nolint:errcheck
 	cryptopool.ReturnToPoolKeccak256(sha)
+	return bloomHashValues(hashbuf)
+}
+
+// bloomHashValues returns the bytes (index-value pairs) to set for the given hash of the data
+func bloomHashValues(hashbuf []byte) (uint, byte, uint, byte, uint, byte) {
 	// The actual bits to flip
 	v1 := byte(1 << (hashbuf[1] & 0x7))
 	v2 := byte(1 << (hashbuf[3] & 0x7))
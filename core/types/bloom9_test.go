@@ -20,14 +20,18 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/big"
+	"os"
 	"testing"
 
 	"github.com/holiman/uint256"
 
 	libcommon "github.com/erigontech/erigon-lib/common"
+	"github.com/erigontech/erigon-lib/common/hexutility"
 	"github.com/erigontech/erigon-lib/crypto"
+	"github.com/erigontech/erigon-lib/rlp"
 )
 
 func TestBloom(t *testing.T) {
@@ -83,6 +87,180 @@ func TestBloomExtensively(t *testing.T) {
 	}
 }
 
+func TestBloomAddLog(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Address: libcommon.BytesToAddress([]byte{0x11}), Topics: []libcommon.Hash{libcommon.BytesToHash([]byte{1}), libcommon.BytesToHash([]byte{2})}},
+		{Address: libcommon.BytesToAddress([]byte{0x22})},
+		{Address: libcommon.BytesToAddress([]byte{0x33}), Topics: []libcommon.Hash{libcommon.BytesToHash([]byte{3})}},
+	}
+	var b Bloom
+	for i, l := range logs {
+		b.AddLog(l)
+		if want := logs[:i+1].Bloom(); b != want {
+			t.Fatalf("after %d logs: got %x, want %x", i+1, b[:], want[:])
+		}
+	}
+}
+
+// mainnetReceiptLogs returns the logs and logsBloom of the receipt of mainnet
+// transaction 0xa3ece39ae137617669c6933b7578b94e705e765683f260fcfe30eaa41932610f in
+// block 5417333, as served by eth_getTransactionReceipt.
+func mainnetReceiptLogs() (Logs, Bloom) {
+	var (
+		token = libcommon.HexToAddress("0xd6df5935cd03a768b7b9e92637a01b25e24cb709")
+		to    = libcommon.HexToHash("0x000000000000000000000000d907941c8b3b966546fc408b8c942eb10a4f98df")
+		value = libcommon.FromHex("0x0000000000000000000000000000000000000000000000000000008bb2c97000")
+	)
+	logs := Logs{
+		{
+			Address: token,
+			Topics:  []libcommon.Hash{libcommon.HexToHash("0x8940c4b8e215f8822c5c8f0056c12652c746cbc57eedbd2a440b175971d47a77"), to},
+			Data:    value,
+		},
+		{
+			Address: token,
+			Topics:  []libcommon.Hash{libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"), {}, to},
+			Data:    value,
+		},
+	}
+	bloom := BytesToBloom(libcommon.FromHex("0x00000000000000000000000000000000000000000000000000000000000000000000000000000000000000008000000000000000000000000000000000000000000000000000000000000008000000000000000000000000000000000000000000000000020000000000000000000800000000000000004010000010100000000000000000000000000000000000000000000000000040000080000000000000080000000000000000000000000000000000000000000020000000000000000000000002000000000000000000000000000000000000000000000000000020000000010000000000000000000000000000000000000000000000000000000000"))
+	return logs, bloom
+}
+
+// block1001001Bloom is the header logsBloom of mainnet block 1001001, whose four
+// transactions are plain value transfers emitting no logs.
+var block1001001Bloom = BytesToBloom(libcommon.FromHex("0x00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
+
+func TestComputeLogsBloom(t *testing.T) {
+	t.Parallel()
+	transfer := libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	logs := Logs{
+		{
+			Address: libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7"),
+			Topics: []libcommon.Hash{
+				transfer,
+				libcommon.HexToHash("0x000000000000000000000000a9d1e08c7793af67e9d92fe308d5697fb81d3e43"),
+				libcommon.HexToHash("0x00000000000000000000000077696bb39917c91a0c3908d577d5e322095425ca"),
+			},
+			Data: libcommon.FromHex("0x0000000000000000000000000000000000000000000000000000000001312d00"),
+		},
+		{Address: libcommon.HexToAddress("0xc02aaa39b223fe8d0a0e5c4f27ead083c756cc2")},
+	}
+
+	// Derive the bloom straight from the yellow paper definition, one bit at a time.
+	var want [BloomByteLength]byte
+	for _, l := range logs {
+		items := [][]byte{l.Address[:]}
+		for _, topic := range l.Topics {
+			items = append(items, topic[:])
+		}
+		for _, item := range items {
+			h := crypto.Keccak256(item)
+			for i := 0; i < 6; i += 2 {
+				bit := (uint(h[i])<<8 | uint(h[i+1])) & 2047
+				want[BloomByteLength-1-bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+
+	got := ComputeLogsBloom(logs)
+	if got != want {
+		t.Fatalf("got %x\nwant %x", got[:], want[:])
+	}
+	if header := CreateBloom(Receipts{{Logs: logs[:1]}, {Logs: logs[1:]}}); got != header {
+		t.Fatalf("differs from the receipts bloom %x", header[:])
+	}
+	if ComputeLogsBloom(nil) != [BloomByteLength]byte(block1001001Bloom) {
+		t.Fatal("bloom of no logs must be the empty header bloom of block 1001001")
+	}
+
+	// fixed vector: a mainnet receipt bloom is the bloom of that receipt's logs
+	receiptLogs, receiptBloom := mainnetReceiptLogs()
+	if got := ComputeLogsBloom(receiptLogs); got != [BloomByteLength]byte(receiptBloom) {
+		t.Fatalf("mainnet receipt: got %x\nwant %x", got[:], receiptBloom[:])
+	}
+
+	// fixed vector: the bloom of every log in a mainnet block is the header bloom
+	receipts := mainnetBlock18663292Receipts(t)
+	if root := DeriveSha(receipts); root != block18663292ReceiptsRoot {
+		t.Fatalf("receipts of block 18663292 incomplete: root %x, want %x", root, block18663292ReceiptsRoot)
+	}
+	var blockLogs Logs
+	for _, r := range receipts {
+		blockLogs = append(blockLogs, r.Logs...)
+	}
+	if got := ComputeLogsBloom(blockLogs); got != [BloomByteLength]byte(block18663292Bloom) {
+		t.Fatalf("block 18663292: got %x\nwant %x", got[:], block18663292Bloom[:])
+	}
+}
+
+// Ethereum mainnet block 18663292
+// (0x9c91beeb3cac7d20698a55bb8cfbe5697222f991f3b5e97daac045882384f94a).
+var (
+	block18663292ReceiptsRoot = libcommon.HexToHash("0x28ddda625886c362d0c6ca0cda2575310533d9fe3354bdd0ec55797120b6f080")
+	block18663292Bloom        = BytesToBloom(hexutility.MustDecodeHex("0x0021000001a0a01000041002800050001000000000010100810100000422040000002000000000004a02082000801100020101008800200020000008002908000000200800008909080040080400002080940004005108000800004080600040000000000000000000080c40000004002400000000000400140100500008040008408044000000002000810100000002000001010100000880000040001004800221000000002040021200800100060004000000000a0000002000000008008000400006080000000000080000000040000400400000101000000002002202400012200000800000028514000001020000021001008400400000000080010410"))
+)
+
+// mainnetBlock18663292Receipts loads the receipts of mainnet block 18663292 as
+// returned by debug_getRawReceipts (testdata/receipts_18663292.json).
+func mainnetBlock18663292Receipts(t *testing.T) Receipts {
+	t.Helper()
+	data, err := os.ReadFile("testdata/receipts_18663292.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []hexutility.Bytes
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	receipts := make(Receipts, len(raw))
+	for i, enc := range raw {
+		// typed receipts come wrapped in an RLP string
+		var inner []byte
+		if err := rlp.DecodeBytes(enc, &inner); err == nil && len(inner) > 0 && inner[0] < 0x7f {
+			enc = inner
+		}
+		receipts[i] = new(Receipt)
+		if err := receipts[i].UnmarshalBinary(enc); err != nil {
+			t.Fatalf("receipt %d: %v", i, err)
+		}
+	}
+	return receipts
+}
+
+func TestLogInRawBloom(t *testing.T) {
+	t.Parallel()
+	logs, bloom := mainnetReceiptLogs()
+	raw := [BloomByteLength]byte(bloom)
+	for i, l := range logs {
+		if !LogInRawBloom(l, raw) {
+			t.Errorf("log %d not found in the bloom of its receipt", i)
+		}
+	}
+	// the address and first topic are in the bloom but the recipient is not
+	foreign := &Log{Address: logs[1].Address, Topics: []libcommon.Hash{logs[1].Topics[0], libcommon.HexToHash("0x00000000000000000000000016a9c11e229ce221578a9adb3e7c0a48482e8063")}}
+	if LogInRawBloom(foreign, raw) {
+		t.Error("log with a topic outside the bloom found")
+	}
+	// a BNB transfer of mainnet block 4001004
+	bnb := &Log{
+		Address: libcommon.HexToAddress("0xb8c77482e45f1f44de1745f52c74426c631bdd52"),
+		Topics: []libcommon.Hash{
+			logs[1].Topics[0],
+			libcommon.HexToHash("0x000000000000000000000000001866ae5b3de6caa5a51543fd9fb64f524f5478"),
+			libcommon.HexToHash("0x00000000000000000000000016a9c11e229ce221578a9adb3e7c0a48482e8063"),
+		},
+	}
+	if LogInRawBloom(bnb, raw) {
+		t.Error("log of another block found")
+	}
+	// mainnet block 1001001 only has plain transfers, its header logsBloom is empty
+	if LogInRawBloom(logs[0], [BloomByteLength]byte(block1001001Bloom)) {
+		t.Error("log found in an empty header bloom")
+	}
+}
+
 func BenchmarkBloom9(b *testing.B) {
 	test := []byte("testestestest")
 	for i := 0; i < b.N; i++ {
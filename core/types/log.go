@@ -20,15 +20,43 @@
 package types
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
+	"math"
+	"math/bits"
+	"regexp"
 	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/golang/snappy"
+	"github.com/holiman/uint256"
 
 	"github.com/erigontech/erigon-lib/common/hexutil"
 
 	libcommon "github.com/erigontech/erigon-lib/common"
 	"github.com/erigontech/erigon-lib/common/hexutility"
+	"github.com/erigontech/erigon-lib/common/length"
+	"github.com/erigontech/erigon-lib/crypto"
+	"github.com/erigontech/erigon-lib/gointerfaces"
+	remote "github.com/erigontech/erigon-lib/gointerfaces/remoteproto"
+	typesproto "github.com/erigontech/erigon-lib/gointerfaces/typesproto"
 
 	"github.com/erigontech/erigon-lib/rlp"
+
+	"github.com/erigontech/erigon/ethdb/cbor"
 )
 
 //(go:generate gencodec -type Log -field-override logMarshaling -out gen_log_json.go)
@@ -61,6 +89,10 @@ type Log struct {
 	// The Removed field is true if this log was reverted due to a chain reorganisation.
 	// You must pay attention to this field if you receive logs through a filter query.
 	Removed bool `json:"removed" codec:"-"`
+
+	// TxSuccess tells whether the emitting transaction succeeded. It is only set when
+	// replaying speculative executions, nil means the transaction succeeded.
+	TxSuccess *bool `json:"-" codec:"-"`
 }
 
 type ErigonLog struct {
@@ -74,10 +106,34 @@ type ErigonLog struct {
 	Index       uint              `json:"logIndex" codec:"-"`
 	Removed     bool              `json:"removed" codec:"-"`
 	Timestamp   uint64            `json:"timestamp" codec:"-"`
+	TxSuccess   *bool             `json:"-" codec:"-"`
 }
 
 type ErigonLogs []*ErigonLog
 
+func (logs ErigonLogs) Copy() ErigonLogs {
+	if logs == nil {
+		return nil
+	}
+	logsCopy := make(ErigonLogs, len(logs))
+	for i, log := range logs {
+		logsCopy[i] = log.Copy()
+	}
+	return logsCopy
+}
+
+// FilterByTimeRange returns the logs whose block timestamp is within [from, to].
+// Logs with a zero Timestamp, which hasn't been populated, are always excluded.
+func (logs ErigonLogs) FilterByTimeRange(from, to uint64) ErigonLogs {
+	o := make(ErigonLogs, 0, len(logs))
+	for _, l := range logs {
+		if l.Timestamp != 0 && l.Timestamp >= from && l.Timestamp <= to {
+			o = append(o, l)
+		}
+	}
+	return o
+}
+
 type Logs []*Log
 
 func (logs Logs) Copy() Logs {
@@ -147,6 +203,155 @@ func (logs Logs) Filter(addrMap map[libcommon.Address]struct{}, topics [][]libco
 	return o
 }
 
+// FilterIgnoringPositions works like Filter, but the topic positions listed in ignore
+// are treated as wildcards regardless of what topics specifies for them.
+func (logs Logs) FilterIgnoringPositions(addrMap map[libcommon.Address]struct{}, topics [][]libcommon.Hash, ignore []int, maxLogs uint64) Logs {
+	if len(ignore) == 0 {
+		return logs.Filter(addrMap, topics, maxLogs)
+	}
+	masked := slices.Clone(topics)
+	for _, idx := range ignore {
+		if idx >= 0 && idx < len(masked) {
+			masked[idx] = nil
+		}
+	}
+	return logs.Filter(addrMap, masked, maxLogs)
+}
+
+// FilterDistinctTopics works like Filter and, when requireDistinct is set, also rejects
+// logs carrying the same topic value more than once. maxLogs limits the number of
+// returned logs, zero means no limit.
+func (logs Logs) FilterDistinctTopics(addrMap map[libcommon.Address]struct{}, topics [][]libcommon.Hash, requireDistinct bool, maxLogs uint64) Logs {
+	if requireDistinct {
+		distinct := make(Logs, 0, len(logs))
+		for _, v := range logs {
+			if !hasDuplicateTopics(v.Topics) {
+				distinct = append(distinct, v)
+			}
+		}
+		logs = distinct
+	}
+	o := logs.Filter(addrMap, topics, 0)
+	if maxLogs != 0 && uint64(len(o)) > maxLogs {
+		o = o[:maxLogs]
+	}
+	return o
+}
+
+// FilterWithScanCount matches logs like Filter and also returns how many logs were
+// examined to produce the result. Unlike Filter, maxLogs limits the number of
+// returned logs, zero means no limit; the scan stops at the match reaching it.
+func (logs Logs) FilterWithScanCount(addrMap map[libcommon.Address]struct{}, topics [][]libcommon.Hash, maxLogs uint64) (Logs, int) {
+	cf := CompileFilter(addrMap, topics)
+	o := make(Logs, 0, len(logs))
+	for i, v := range logs {
+		if !cf.Match(v) {
+			continue
+		}
+		o = append(o, v)
+		if maxLogs != 0 && uint64(len(o)) >= maxLogs {
+			return o, i + 1
+		}
+	}
+	return o, len(logs)
+}
+
+// hasDuplicateTopics reports whether a topic value repeats. Logs carry at most four
+// topics, so a pairwise comparison is cheaper than a set.
+func hasDuplicateTopics(topics []libcommon.Hash) bool {
+	for i := 1; i < len(topics); i++ {
+		if slices.Contains(topics[:i], topics[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterWorkspace holds the topic lookup maps used by Filter so they can be reused
+// across calls, avoiding their reallocation in tight loops.
+// A FilterWorkspace is not safe for concurrent use.
+type FilterWorkspace struct {
+	topicMap []map[libcommon.Hash]struct{}
+}
+
+// Filter matches logs like Logs.Filter, but reuses the workspace maps. Unlike
+// Logs.Filter, maxLogs limits the number of returned logs, zero means no limit.
+func (w *FilterWorkspace) Filter(logs Logs, addrMap map[libcommon.Address]struct{}, topics [][]libcommon.Hash, maxLogs uint64) Logs {
+	for len(w.topicMap) < len(topics) {
+		w.topicMap = append(w.topicMap, map[libcommon.Hash]struct{}{})
+	}
+	topicMap := w.topicMap[:len(topics)]
+	for idx, v := range topics {
+		clear(topicMap[idx])
+		for _, vv := range v {
+			topicMap[idx][vv] = struct{}{}
+		}
+	}
+
+	o := make(Logs, 0, len(logs))
+	for _, v := range logs {
+		if len(addrMap) != 0 {
+			if _, ok := addrMap[v.Address]; !ok {
+				continue
+			}
+		}
+		if len(topics) > len(v.Topics) {
+			continue
+		}
+		found := true
+		for idx, topicSet := range topicMap {
+			// an empty topicSet is a wildcard
+			if len(topicSet) == 0 {
+				continue
+			}
+			if _, ok := topicSet[v.Topics[idx]]; !ok {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		o = append(o, v)
+		if maxLogs != 0 && uint64(len(o)) >= maxLogs {
+			break
+		}
+	}
+	return o
+}
+
+// FlattenTopics collects the topics of a positional filter, as used by Logs.Filter,
+// into the set used by Logs.CointainTopics. Positions are lost, so the set matches
+// logs having any of the topics anywhere, a superset of those matched positionally.
+// Wildcard positions contribute nothing.
+func FlattenTopics(positional [][]libcommon.Hash) map[libcommon.Hash]struct{} {
+	flat := make(map[libcommon.Hash]struct{})
+	for _, alternatives := range positional {
+		for _, topic := range alternatives {
+			flat[topic] = struct{}{}
+		}
+	}
+	return flat
+}
+
+// PositionalFromFlat builds a positional filter, as used by Logs.Filter, requiring
+// one of the topics of flat at position and any topic before it. The alternatives
+// are sorted. An empty flat or a negative position gives a nil filter, which
+// matches every log.
+func PositionalFromFlat(flat map[libcommon.Hash]struct{}, position int) [][]libcommon.Hash {
+	if len(flat) == 0 || position < 0 {
+		return nil
+	}
+	alternatives := make([]libcommon.Hash, 0, len(flat))
+	for topic := range flat {
+		alternatives = append(alternatives, topic)
+	}
+	slices.SortFunc(alternatives, func(a, b libcommon.Hash) int { return bytes.Compare(a[:], b[:]) })
+	positional := make([][]libcommon.Hash, position+1)
+	positional[position] = alternatives
+	return positional
+}
+
 func (logs Logs) CointainTopics(addrMap map[libcommon.Address]struct{}, topicsMap map[libcommon.Hash]struct{}, maxLogs uint64) Logs {
 	o := make(Logs, 0, len(logs))
 	var logCount uint64
@@ -183,6 +388,41 @@ func (logs Logs) CointainTopics(addrMap map[libcommon.Address]struct{}, topicsMa
 	return o
 }
 
+// FilterAtLeastNTopics returns the logs containing, at any position, at least n of the
+// candidate topics. Each distinct candidate counts once, however often it appears in
+// the log. With n == 1 this behaves like CointainTopics, with n == len(candidates) it
+// requires all of them. An empty addrMap matches any address. maxLogs limits the
+// number of returned logs, zero means no limit.
+func (logs Logs) FilterAtLeastNTopics(addrMap map[libcommon.Address]struct{}, candidates []libcommon.Hash, n int, maxLogs uint64) Logs {
+	candidateSet := make(map[libcommon.Hash]struct{}, len(candidates))
+	for _, c := range candidates {
+		candidateSet[c] = struct{}{}
+	}
+	seen := make(map[libcommon.Hash]struct{}, maxLogTopics)
+	o := make(Logs, 0, len(logs))
+	for _, v := range logs {
+		if len(addrMap) != 0 {
+			if _, ok := addrMap[v.Address]; !ok {
+				continue
+			}
+		}
+		clear(seen)
+		for _, topic := range v.Topics {
+			if _, ok := candidateSet[topic]; ok {
+				seen[topic] = struct{}{}
+			}
+		}
+		if len(seen) < n {
+			continue
+		}
+		o = append(o, v)
+		if maxLogs != 0 && uint64(len(o)) >= maxLogs {
+			break
+		}
+	}
+	return o
+}
+
 func (logs Logs) FilterOld(addresses map[libcommon.Address]struct{}, topics [][]libcommon.Hash) Logs {
 	result := make(Logs, 0, len(logs))
 	// populate a set of addresses
@@ -218,112 +458,2313 @@ Logs:
 	return result
 }
 
-type logMarshaling struct {
-	Data        hexutility.Bytes
-	BlockNumber hexutil.Uint64
-	TxIndex     hexutil.Uint
-	Index       hexutil.Uint
+// FilterTopicAt returns the logs whose topic at position equals topic. It is a
+// shortcut for Filter with a single topic at a single position, without building
+// the positional topic list. maxLogs limits the number of returned logs, zero
+// means no limit.
+func (logs Logs) FilterTopicAt(position int, topic libcommon.Hash, maxLogs uint64) Logs {
+	o := Logs{}
+	if position < 0 {
+		return o
+	}
+	for _, v := range logs {
+		if position >= len(v.Topics) || v.Topics[position] != topic {
+			continue
+		}
+		o = append(o, v)
+		if maxLogs != 0 && uint64(len(o)) >= maxLogs {
+			break
+		}
+	}
+	return o
 }
 
-type rlpLog struct {
-	Address libcommon.Address
-	Topics  []libcommon.Hash
-	Data    []byte
+// FilterAddressInTopics returns the logs having addr, in its indexed topic form, at
+// any topic position, e.g. the transfers from or to a wallet. maxLogs limits the
+// number of returned logs, zero means no limit.
+func (logs Logs) FilterAddressInTopics(addr libcommon.Address, maxLogs uint64) Logs {
+	topic := AddressToTopic(addr)
+	return logs.FilterPred(func(l *Log) bool { return slices.Contains(l.Topics, topic) }, maxLogs)
 }
 
-// rlpStorageLog is the storage encoding of a log.
-type rlpStorageLog rlpLog
+// FilterPerAddressSignatures returns the logs whose address is a key of allow and
+// whose signature, the first topic, is in the set of that address. Unlike a topic
+// filter shared by all addresses, each contract is matched against its own events.
+// An empty allow matches nothing. maxLogs limits the number of returned logs, zero
+// means no limit.
+func (logs Logs) FilterPerAddressSignatures(allow map[libcommon.Address]map[libcommon.Hash]struct{}, maxLogs uint64) Logs {
+	return logs.FilterPred(func(l *Log) bool {
+		if len(l.Topics) == 0 {
+			return false
+		}
+		_, ok := allow[l.Address][l.Topics[0]]
+		return ok
+	}, maxLogs)
+}
 
-// legacyRlpStorageLog is the previous storage encoding of a log including some redundant fields.
-type legacyRlpStorageLog struct {
+// FilterAnonymousByTopics returns the logs whose topics contain each hash of required
+// at least as many times as its count, at any position. It suits anonymous events,
+// whose topics carry no signature fixing their layout.
+func (logs Logs) FilterAnonymousByTopics(required map[libcommon.Hash]int) Logs {
+	return logs.FilterPred(func(l *Log) bool {
+		have := l.TopicsMultiset()
+		for topic, n := range required {
+			if have[topic] < n {
+				return false
+			}
+		}
+		return true
+	}, 0)
+}
+
+// FilterByTxHashes returns the logs emitted by the transactions in txs, preserving order.
+func (logs Logs) FilterByTxHashes(txs map[libcommon.Hash]struct{}) Logs {
+	o := make(Logs, 0, len(logs))
+	for _, v := range logs {
+		if _, ok := txs[v.TxHash]; ok {
+			o = append(o, v)
+		}
+	}
+	return o
+}
+
+// AddrSig identifies an event of a specific contract: the contract address and the
+// event signature hash, i.e. the first topic.
+type AddrSig struct {
 	Address libcommon.Address
-	Topics  []libcommon.Hash
-	Data    []byte
-	//BlockNumber uint64
-	//TxHash      libcommon.Hash
-	//TxIndex     uint
-	//BlockHash   libcommon.Hash
-	//Index       uint
+	Sig     libcommon.Hash
 }
 
-// EncodeRLP implements rlp.Encoder.
-func (l *Log) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, rlpLog{Address: l.Address, Topics: l.Topics, Data: l.Data})
+// FilterPairs returns the logs whose (Address, Topics[0]) pair is in pairs. Unlike
+// Filter with separate address and topic sets, it doesn't match the cross product of
+// all addresses and signatures. maxLogs limits the number of returned logs, zero
+// means no limit.
+func (logs Logs) FilterPairs(pairs map[AddrSig]struct{}, maxLogs uint64) Logs {
+	o := make(Logs, 0, len(logs))
+	for _, v := range logs {
+		if len(v.Topics) == 0 {
+			continue
+		}
+		if _, ok := pairs[AddrSig{Address: v.Address, Sig: v.Topics[0]}]; !ok {
+			continue
+		}
+		o = append(o, v)
+		if maxLogs != 0 && uint64(len(o)) >= maxLogs {
+			break
+		}
+	}
+	return o
 }
 
-// DecodeRLP implements rlp.Decoder.
-func (l *Log) DecodeRLP(s *rlp.Stream) error {
-	var dec rlpLog
-	err := s.Decode(&dec)
-	if err == nil {
-		l.Address, l.Topics, l.Data = dec.Address, dec.Topics, dec.Data
+// LatestPerPair returns, for every (Address, Topics[0]) pair, only the most recent log
+// by (BlockNumber, TxIndex, Index). Logs without topics are dropped. The result is in
+// canonical order.
+func (logs Logs) LatestPerPair() Logs {
+	latest := make(map[AddrSig]*Log)
+	for _, v := range logs {
+		if len(v.Topics) == 0 {
+			continue
+		}
+		key := AddrSig{Address: v.Address, Sig: v.Topics[0]}
+		if prev, ok := latest[key]; !ok || compareLogsCanonical(v, prev) >= 0 {
+			latest[key] = v
+		}
 	}
-	return err
+	o := make(Logs, 0, len(latest))
+	for _, v := range latest {
+		o = append(o, v)
+	}
+	o.Sort()
+	return o
 }
 
-// Copy creates a deep copy of the Log.
-func (l *Log) Copy() *Log {
-	if l == nil {
-		return nil
+// FilterSuccessfulOnly returns the logs emitted by successful transactions.
+func (logs Logs) FilterSuccessfulOnly() Logs {
+	o := make(Logs, 0, len(logs))
+	for _, v := range logs {
+		if v.Succeeded() {
+			o = append(o, v)
+		}
 	}
-	return &Log{
-		Address:     libcommon.BytesToAddress(l.Address.Bytes()),
-		Topics:      slices.Clone(l.Topics),
-		Data:        slices.Clone(l.Data),
-		BlockNumber: l.BlockNumber,
-		TxHash:      libcommon.BytesToHash(l.TxHash.Bytes()),
-		TxIndex:     l.TxIndex,
-		BlockHash:   libcommon.BytesToHash(l.BlockHash.Bytes()),
-		Index:       l.Index,
-		Removed:     l.Removed,
+	return o
+}
+
+// SetTxSuccess sets TxSuccess of each log to the receipt status of its transaction
+// in statusByTx. Logs of transactions missing from statusByTx get TxSuccess cleared,
+// which marks them successful.
+func (logs Logs) SetTxSuccess(statusByTx map[libcommon.Hash]bool) {
+	for _, l := range logs {
+		status, ok := statusByTx[l.TxHash]
+		if !ok {
+			l.TxSuccess = nil
+			continue
+		}
+		l.TxSuccess = &status
 	}
 }
 
-// LogForStorage is a wrapper around a Log that flattens and parses the entire content of
-// a log including non-consensus fields.
-type LogForStorage Log
+// ReassignTxIndex sets TxIndex of each log to the position of its transaction in
+// txOrder. Logs of transactions missing from txOrder are left unchanged.
+func (logs Logs) ReassignTxIndex(txOrder []libcommon.Hash) {
+	pos := make(map[libcommon.Hash]uint, len(txOrder))
+	for i, txHash := range txOrder {
+		if _, ok := pos[txHash]; !ok {
+			pos[txHash] = uint(i)
+		}
+	}
+	for _, l := range logs {
+		if i, ok := pos[l.TxHash]; ok {
+			l.TxIndex = i
+		}
+	}
+}
 
-// EncodeRLP implements rlp.Encoder.
-func (l *LogForStorage) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, rlpStorageLog{
-		Address: l.Address,
-		Topics:  l.Topics,
-		Data:    l.Data,
-		//BlockNumber: l.BlockNumber,
-		//TxHash:      l.TxHash,
-		//TxIndex:     l.TxIndex,
-		//BlockHash:   l.BlockHash,
-		//Index:       l.Index,
-	})
+// FilterByDataLen returns the logs whose data length is within [minLen, maxLen].
+// A negative maxLen means no upper bound. maxLogs limits the number of returned logs,
+// zero means no limit.
+func (logs Logs) FilterByDataLen(minLen, maxLen int, maxLogs uint64) Logs {
+	return logs.FilterPred(ByDataLen(minLen, maxLen), maxLogs)
 }
 
-// DecodeRLP implements rlp.Decoder.
-//
-// Note some redundant fields(e.g. block number, txn hash etc) will be assembled later.
-func (l *LogForStorage) DecodeRLP(s *rlp.Stream) error {
-	blob, err := s.Raw()
-	if err != nil {
-		return err
+// FilterDataContains returns the logs whose data contains pattern anywhere. An empty
+// pattern matches every log. maxLogs limits the number of returned logs, zero means
+// no limit.
+func (logs Logs) FilterDataContains(pattern []byte, maxLogs uint64) Logs {
+	return logs.FilterPred(func(l *Log) bool { return bytes.Contains(l.Data, pattern) }, maxLogs)
+}
+
+// FilterDataRegexp returns the logs whose data, as lowercase hex without 0x prefix,
+// matches re. Two hex digits per byte means a match may start in the middle of a
+// byte unless re anchors it. Every log's data is hex encoded and scanned, so this is
+// expensive and meant for ad-hoc investigation, not serving queries. maxLogs limits
+// the number of returned logs, zero means no limit.
+func (logs Logs) FilterDataRegexp(re *regexp.Regexp, maxLogs uint64) Logs {
+	return logs.FilterPred(func(l *Log) bool { return re.MatchString(hex.EncodeToString(l.Data)) }, maxLogs)
+}
+
+// FilterByWordCount returns the logs whose data is exactly words 32-byte ABI words
+// long. A negative words matches any data. maxLogs limits the number of returned
+// logs, zero means no limit.
+func (logs Logs) FilterByWordCount(words int, maxLogs uint64) Logs {
+	if words < 0 {
+		return logs.FilterPred(ByDataLen(0, -1), maxLogs)
 	}
-	var dec rlpStorageLog
-	err = rlp.DecodeBytes(blob, &dec)
-	if err == nil {
-		*l = LogForStorage{
-			Address: dec.Address,
-			Topics:  dec.Topics,
-			Data:    dec.Data,
-		}
-	} else {
-		// Try to decode log with previous definition.
-		var dec legacyRlpStorageLog
-		err = rlp.DecodeBytes(blob, &dec)
-		if err == nil {
-			*l = LogForStorage{
-				Address: dec.Address,
-				Topics:  dec.Topics,
-				Data:    dec.Data,
-			}
+	return logs.FilterPred(ByDataLen(words*length.Hash, words*length.Hash), maxLogs)
+}
+
+// FilterOlderThan returns the logs below blockNumber, the ones a retention policy
+// keeping blocks from blockNumber on prunes.
+func (logs Logs) FilterOlderThan(blockNumber uint64) Logs {
+	return logs.FilterPred(func(l *Log) bool { return l.BlockNumber < blockNumber }, 0)
+}
+
+// FilterNewerOrEqual returns the logs at or above blockNumber, the complement of FilterOlderThan.
+func (logs Logs) FilterNewerOrEqual(blockNumber uint64) Logs {
+	return logs.FilterPred(func(l *Log) bool { return l.BlockNumber >= blockNumber }, 0)
+}
+
+// compareLogsCanonical orders logs by (BlockNumber, TxIndex, Index).
+func compareLogsCanonical(a, b *Log) int {
+	if c := cmp.Compare(a.BlockNumber, b.BlockNumber); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.TxIndex, b.TxIndex); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Index, b.Index)
+}
+
+// IsCanonicalOrder reports whether logs are sorted by (BlockNumber, TxIndex, Index).
+func (logs Logs) IsCanonicalOrder() bool {
+	return slices.IsSortedFunc(logs, compareLogsCanonical)
+}
+
+// Sort sorts logs in place by (BlockNumber, TxIndex, Index).
+// It is a no-op for logs which are already in that order.
+func (logs Logs) Sort() {
+	if logs.IsCanonicalOrder() {
+		return
+	}
+	slices.SortStableFunc(logs, compareLogsCanonical)
+}
+
+// FindDuplicateIndices returns the pairs of positions of logs sharing both BlockHash
+// and Index. Each repeat is paired with the first log holding that index, so three
+// logs with the same index give two pairs.
+func (logs Logs) FindDuplicateIndices() [][2]int {
+	type blockIndex struct {
+		blockHash libcommon.Hash
+		index     uint
+	}
+	first := make(map[blockIndex]int, len(logs))
+	var o [][2]int
+	for i, l := range logs {
+		key := blockIndex{l.BlockHash, l.Index}
+		if j, ok := first[key]; ok {
+			o = append(o, [2]int{j, i})
+			continue
 		}
+		first[key] = i
 	}
-	return err
+	return o
+}
+
+// RepairIndices returns copies of logs in canonical order with Index renumbered from
+// zero within each block, closing gaps and splitting duplicates. Logs with the same
+// (BlockNumber, TxIndex, Index) keep their relative order.
+func (logs Logs) RepairIndices() Logs {
+	o := make(Logs, len(logs))
+	for i, l := range logs {
+		o[i] = l.Copy()
+	}
+	slices.SortStableFunc(o, compareLogsCanonical)
+	next := make(map[libcommon.Hash]uint)
+	for _, l := range o {
+		l.Index = next[l.BlockHash]
+		next[l.BlockHash]++
+	}
+	return o
+}
+
+// RebuildCanonicalOrder returns a copy of the slice of logs of a block, e.g. decoded
+// by parallel workers, sorted by the position of their transaction in txOrder and
+// then by Index. Logs of transactions missing from txOrder go last, in their
+// original order.
+func RebuildCanonicalOrder(logs Logs, txOrder map[libcommon.Hash]uint) Logs {
+	o := slices.Clone(logs)
+	slices.SortStableFunc(o, func(a, b *Log) int {
+		ai, aok := txOrder[a.TxHash]
+		bi, bok := txOrder[b.TxHash]
+		switch {
+		case aok != bok:
+			if aok {
+				return -1
+			}
+			return 1
+		case !aok:
+			return 0
+		case ai != bi:
+			return cmp.Compare(ai, bi)
+		}
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return o
+}
+
+var ErrLogsMisordered = errors.New("logs out of order")
+
+// ValidateOrdering checks that the logs of a block are in block order: Index strictly
+// increasing and TxIndex non-decreasing. The error describes the first violation.
+func (logs Logs) ValidateOrdering() error {
+	for i := 1; i < len(logs); i++ {
+		prev, cur := logs[i-1], logs[i]
+		if cur.Index <= prev.Index {
+			return fmt.Errorf("%w: log %d has index %d, not above index %d of log %d", ErrLogsMisordered, i, cur.Index, prev.Index, i-1)
+		}
+		if cur.TxIndex < prev.TxIndex {
+			return fmt.Errorf("%w: log %d has tx index %d, below tx index %d of log %d", ErrLogsMisordered, i, cur.TxIndex, prev.TxIndex, i-1)
+		}
+	}
+	return nil
+}
+
+// SortInterface adapts logs to sort.Interface, ordering them with less. Use it with
+// sort.Stable to keep the relative order of equal logs.
+func (logs Logs) SortInterface(less func(a, b *Log) bool) sort.Interface {
+	return logsSorter{logs: logs, less: less}
+}
+
+type logsSorter struct {
+	logs Logs
+	less func(a, b *Log) bool
+}
+
+func (s logsSorter) Len() int           { return len(s.logs) }
+func (s logsSorter) Less(i, j int) bool { return s.less(s.logs[i], s.logs[j]) }
+func (s logsSorter) Swap(i, j int)      { s.logs[i], s.logs[j] = s.logs[j], s.logs[i] }
+
+// LessByBlockThenIndex orders logs by block number, then by index within the block.
+func LessByBlockThenIndex(a, b *Log) bool {
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber < b.BlockNumber
+	}
+	return a.Index < b.Index
+}
+
+// LessByAddress orders logs by the bytes of their emitting address.
+func LessByAddress(a, b *Log) bool {
+	return bytes.Compare(a.Address[:], b.Address[:]) < 0
+}
+
+// Page returns the logs in the window [offset, offset+limit), clamped to the slice
+// bounds, and whether any logs follow the window. The page aliases logs.
+func (logs Logs) Page(offset, limit int) (page Logs, hasMore bool) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	if offset >= len(logs) {
+		return Logs{}, false
+	}
+	end := len(logs)
+	if limit < end-offset {
+		end = offset + limit
+	}
+	return logs[offset:end], end < len(logs)
+}
+
+// Reverse reverses the order of logs in place.
+func (logs Logs) Reverse() {
+	slices.Reverse(logs)
+}
+
+// Reversed returns a new slice holding logs in reverse order, leaving logs untouched.
+func (logs Logs) Reversed() Logs {
+	if logs == nil {
+		return nil
+	}
+	o := make(Logs, len(logs))
+	for i, l := range logs {
+		o[len(logs)-1-i] = l
+	}
+	return o
+}
+
+// NextLogIndex returns the block-wide Index to give the first log of the next
+// transaction appended after existing: one past the largest Index seen, or 0 when
+// there are no logs yet.
+func NextLogIndex(existing Logs) uint {
+	var next uint
+	for _, l := range existing {
+		next = max(next, l.Index+1)
+	}
+	return next
+}
+
+// TxLogContext is the per-transaction context needed to fill the derived fields of its logs.
+type TxLogContext struct {
+	TxHash       libcommon.Hash
+	LogIndexBase uint // block-wide index of the first log of the transaction
+}
+
+// MaxLogIndex is the largest Index or TxIndex the assembly helpers assign, so that
+// indices fit uint on 32-bit platforms.
+const MaxLogIndex = math.MaxUint32
+
+var ErrLogIndexOverflow = errors.New("log index overflow")
+
+// AssembleFromTxContexts fills the derived fields of block logs in place. Each log is
+// looked up in ctxs by its TxIndex and is numbered from the LogIndexBase of its
+// transaction in the order the logs of that transaction appear. Logs of transactions
+// missing from ctxs only get the block fields set. If an index would exceed
+// MaxLogIndex, it fails with ErrLogIndexOverflow without modifying any log.
+func (logs Logs) AssembleFromTxContexts(blockNumber uint64, blockHash libcommon.Hash, ctxs map[uint]TxLogContext) error {
+	count := make(map[uint]uint64, len(ctxs))
+	for _, l := range logs {
+		if _, ok := ctxs[l.TxIndex]; ok {
+			count[l.TxIndex]++
+		}
+	}
+	for txIndex, n := range count {
+		if txIndex > MaxLogIndex {
+			return fmt.Errorf("%w: tx index %d above %d", ErrLogIndexOverflow, txIndex, uint64(MaxLogIndex))
+		}
+		if last := uint64(ctxs[txIndex].LogIndexBase) + n - 1; last > MaxLogIndex {
+			return fmt.Errorf("%w: tx %d numbers logs up to %d, above %d", ErrLogIndexOverflow, txIndex, last, uint64(MaxLogIndex))
+		}
+	}
+
+	next := make(map[uint]uint, len(ctxs))
+	for _, l := range logs {
+		l.BlockNumber = blockNumber
+		l.BlockHash = blockHash
+		ctx, ok := ctxs[l.TxIndex]
+		if !ok {
+			continue
+		}
+		l.TxHash = ctx.TxHash
+		l.Index = ctx.LogIndexBase + next[l.TxIndex]
+		next[l.TxIndex]++
+	}
+	return nil
+}
+
+// logKey identifies a log across snapshots and sources.
+type logKey struct {
+	blockHash, txHash libcommon.Hash
+	index             uint
+}
+
+func keyOf(l *Log) logKey { return logKey{l.BlockHash, l.TxHash, l.Index} }
+
+// DeltaTo compares two snapshots of the same query and returns the logs of next
+// that are not in prev and the logs of prev that are not in next, each in their
+// snapshot's order. Logs are identified by (BlockHash, TxHash, Index), so a log
+// moved to another block by a reorg shows up as both removed and added.
+func (prev Logs) DeltaTo(next Logs) (added Logs, removed Logs) {
+	inPrev := make(map[logKey]struct{}, len(prev))
+	for _, l := range prev {
+		inPrev[keyOf(l)] = struct{}{}
+	}
+	inNext := make(map[logKey]struct{}, len(next))
+	for _, l := range next {
+		k := keyOf(l)
+		inNext[k] = struct{}{}
+		if _, ok := inPrev[k]; !ok {
+			added = append(added, l)
+		}
+	}
+	for _, l := range prev {
+		if _, ok := inNext[keyOf(l)]; !ok {
+			removed = append(removed, l)
+		}
+	}
+	return added, removed
+}
+
+// ReorgLogDelta returns the notifications for a reorg from the logs of the old chain
+// to the logs of the new one: copies of the old logs missing from newLogs, with
+// Removed set, and the new logs missing from oldLogs. Logs are compared by their
+// consensus fields only, since block hashes and positions differ between the chains;
+// a log emitted n times is matched n times.
+func ReorgLogDelta(oldLogs, newLogs Logs) (reverted Logs, applied Logs) {
+	count := make(map[libcommon.Hash]int, len(newLogs))
+	for _, l := range newLogs {
+		count[l.ContentHash()]++
+	}
+	for _, l := range oldLogs {
+		k := l.ContentHash()
+		if count[k] > 0 {
+			count[k]--
+			continue
+		}
+		cp := l.Copy()
+		cp.Removed = true
+		reverted = append(reverted, cp)
+	}
+	clear(count)
+	for _, l := range oldLogs {
+		count[l.ContentHash()]++
+	}
+	for _, l := range newLogs {
+		k := l.ContentHash()
+		if count[k] > 0 {
+			count[k]--
+			continue
+		}
+		applied = append(applied, l)
+	}
+	return reverted, applied
+}
+
+// NetLogs replays a subscription stream in which reorged logs are re-sent with
+// Removed set and returns the logs still standing, in the order they were added.
+// A removed log cancels the earlier log with the same (BlockHash, TxHash, Index);
+// removals of logs that were never added are ignored, and so are repeated additions.
+func (logs Logs) NetLogs() Logs {
+	added := make(Logs, 0, len(logs))
+	live := make(map[logKey]int, len(logs)) // position in added
+	for _, l := range logs {
+		key := keyOf(l)
+		pos, ok := live[key]
+		switch {
+		case l.Removed && ok:
+			added[pos] = nil
+			delete(live, key)
+		case !l.Removed && !ok:
+			live[key] = len(added)
+			added = append(added, l)
+		}
+	}
+	return slices.DeleteFunc(added, func(l *Log) bool { return l == nil })
+}
+
+// UniqueByDataHash returns the first log of each distinct data content, in order.
+func (logs Logs) UniqueByDataHash() Logs {
+	seen := make(map[libcommon.Hash]struct{}, len(logs))
+	o := make(Logs, 0, len(logs))
+	for _, l := range logs {
+		h := l.DataHash()
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+		o = append(o, l)
+	}
+	return o
+}
+
+// RemapAddresses returns copies of logs with each emitting address replaced by its
+// entry in mapping, if any. Addresses appearing in topics or data are left alone.
+func (logs Logs) RemapAddresses(mapping map[libcommon.Address]libcommon.Address) Logs {
+	o := make(Logs, len(logs))
+	for i, l := range logs {
+		cp := l.Copy()
+		if addr, ok := mapping[l.Address]; ok {
+			cp.Address = addr
+		}
+		o[i] = cp
+	}
+	return o
+}
+
+// RedactTopicsAfter returns copies of logs with every topic past position replaced
+// by the zero hash, so RedactTopicsAfter(0) keeps only the event signature. The
+// number of topics is left unchanged; a negative position redacts them all.
+func (logs Logs) RedactTopicsAfter(position int) Logs {
+	o := make(Logs, len(logs))
+	for i, l := range logs {
+		cp := l.Copy()
+		for j := max(position+1, 0); j < len(cp.Topics); j++ {
+			cp.Topics[j] = libcommon.Hash{}
+		}
+		o[i] = cp
+	}
+	return o
+}
+
+// ConcatDataBySignature concatenates, in order, the data of the logs whose first
+// topic is sig. Logs without topics never match.
+func (logs Logs) ConcatDataBySignature(sig libcommon.Hash) []byte {
+	var n int
+	for _, l := range logs {
+		if len(l.Topics) > 0 && l.Topics[0] == sig {
+			n += len(l.Data)
+		}
+	}
+	o := make([]byte, 0, n)
+	for _, l := range logs {
+		if len(l.Topics) > 0 && l.Topics[0] == sig {
+			o = append(o, l.Data...)
+		}
+	}
+	return o
+}
+
+// TxRelativeIndices returns, per transaction hash, the positions of the transaction's
+// logs within the transaction, in the order of logs. Positions are derived from the
+// block-wide Index relative to the transaction's lowest-indexed log, so logs must
+// hold every log of the transactions it covers.
+func (logs Logs) TxRelativeIndices() map[libcommon.Hash][]uint {
+	first := map[libcommon.Hash]uint{}
+	for _, l := range logs {
+		if idx, ok := first[l.TxHash]; !ok || l.Index < idx {
+			first[l.TxHash] = l.Index
+		}
+	}
+	o := make(map[libcommon.Hash][]uint, len(first))
+	for _, l := range logs {
+		o[l.TxHash] = append(o[l.TxHash], l.Index-first[l.TxHash])
+	}
+	return o
+}
+
+// SplitByRemoved separates the logs with Removed set from the others, keeping the
+// order of each.
+func (logs Logs) SplitByRemoved() (canonical Logs, removed Logs) {
+	for _, l := range logs {
+		if l.Removed {
+			removed = append(removed, l)
+		} else {
+			canonical = append(canonical, l)
+		}
+	}
+	return canonical, removed
+}
+
+// ReconcileRemoved returns copies of logs with Removed set according to canonicalBlocks,
+// a map from block number to canonical block hash: a log is marked removed unless its
+// (BlockNumber, BlockHash) is in the map.
+func (logs Logs) ReconcileRemoved(canonicalBlocks map[uint64]libcommon.Hash) Logs {
+	o := make(Logs, len(logs))
+	for i, l := range logs {
+		cp := l.Copy()
+		hash, ok := canonicalBlocks[l.BlockNumber]
+		cp.Removed = !ok || hash != l.BlockHash
+		o[i] = cp
+	}
+	return o
+}
+
+// Chunk splits logs into consecutive batches of size logs, the last one possibly
+// shorter. The batches share the backing array of logs; each is capped so that
+// appending to one does not overwrite the next. A size <= 0 yields a single batch.
+// Empty logs yield no batches.
+func (logs Logs) Chunk(size int) []Logs {
+	if len(logs) == 0 {
+		return nil
+	}
+	if size <= 0 || size >= len(logs) {
+		return []Logs{logs}
+	}
+	o := make([]Logs, 0, (len(logs)+size-1)/size)
+	for len(logs) > size {
+		o = append(o, logs[:size:size])
+		logs = logs[size:]
+	}
+	return append(o, logs)
+}
+
+// WindowByBlock calls fn for each non-empty window of windowSize blocks, in order.
+// Windows are aligned to multiples of windowSize, startBlock being the first block
+// of the window, and window is a subslice of logs. logs must be sorted by block
+// number. It panics if windowSize is zero.
+func (logs Logs) WindowByBlock(windowSize uint64, fn func(startBlock uint64, window Logs)) {
+	if windowSize == 0 {
+		panic("zero WindowByBlock window size")
+	}
+	for start := 0; start < len(logs); {
+		startBlock := logs[start].BlockNumber - logs[start].BlockNumber%windowSize
+		end := start + 1
+		for end < len(logs) && logs[end].BlockNumber-startBlock < windowSize {
+			end++
+		}
+		fn(startBlock, logs[start:end:end])
+		start = end
+	}
+}
+
+// WriteNDJSON writes logs to w as newline-delimited JSON, one log per line,
+// encoding each log as it goes.
+func (logs Logs) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, l := range logs {
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxLogsFrameSize bounds the payload of a frame accepted by ReadFramedLogs.
+const maxLogsFrameSize = 64 << 20
+
+var ErrLogsFrameTooLarge = errors.New("logs frame exceeds size limit")
+
+// WriteFramed writes logs to w as a single frame: a 4-byte big-endian payload
+// length followed by the RLP-encoded list of logs.
+func (logs Logs) WriteFramed(w io.Writer) error {
+	payload, err := rlp.EncodeToBytes(logs)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxLogsFrameSize {
+		return fmt.Errorf("%w: %d bytes", ErrLogsFrameTooLarge, len(payload))
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFramedLogs reads one frame written by WriteFramed from r. It returns io.EOF
+// if r is exhausted before the frame starts and io.ErrUnexpectedEOF if the frame is cut short.
+func ReadFramedLogs(r io.Reader) (Logs, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxLogsFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrLogsFrameTooLarge, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	var logs Logs
+	if err := rlp.DecodeBytes(payload, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// Table formats logs as a table with aligned columns for debugging. Addresses and
+// signatures, the first topic, are shortened.
+func (logs Logs) Table() string {
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BLOCK\tTX\tLOG\tADDRESS\tSIGNATURE\tDATA\tREMOVED")
+	for _, l := range logs {
+		sig := "-"
+		if len(l.Topics) > 0 {
+			sig = l.Topics[0].Hex()[:10] + "..."
+		}
+		addr := l.Address.Hex()
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s...%s\t%s\t%d\t%t\n",
+			l.BlockNumber, l.TxIndex, l.Index, addr[:8], addr[len(addr)-4:], sig, len(l.Data), l.Removed)
+	}
+	tw.Flush() //nolint:errcheck
+	return sb.String()
+}
+
+// LogSummary is the compact part of a log kept in hot storage: the emitting
+// contract, the event signature and the position of the log in the chain.
+type LogSummary struct {
+	Address     libcommon.Address
+	Signature   libcommon.Hash // first topic, zero for logs without topics
+	BlockNumber uint64
+	TxIndex     uint
+	Index       uint
+}
+
+// Summary returns the LogSummary of l.
+func (l *Log) Summary() LogSummary {
+	s := LogSummary{
+		Address:     l.Address,
+		BlockNumber: l.BlockNumber,
+		TxIndex:     l.TxIndex,
+		Index:       l.Index,
+	}
+	if len(l.Topics) > 0 {
+		s.Signature = l.Topics[0]
+	}
+	return s
+}
+
+// LazyLog is a LogSummary whose full log is fetched from cold storage on first use.
+type LazyLog struct {
+	Summary LogSummary
+
+	fetch func() (*Log, error)
+	once  sync.Once
+	log   *Log
+	err   error
+}
+
+// NewLazyLog creates a LazyLog resolving the full log with fetch.
+func NewLazyLog(summary LogSummary, fetch func() (*Log, error)) *LazyLog {
+	return &LazyLog{Summary: summary, fetch: fetch}
+}
+
+// Resolve returns the full log. fetch is called at most once, its result
+// (including an error) is cached for subsequent calls.
+func (l *LazyLog) Resolve() (*Log, error) {
+	l.once.Do(func() {
+		l.log, l.err = l.fetch()
+		l.fetch = nil
+	})
+	return l.log, l.err
+}
+
+// Bloom returns the bloom filter of the addresses and topics of logs.
+func (logs Logs) Bloom() Bloom {
+	return logs.BloomWith(nil)
+}
+
+// BloomWith returns the bloom filter of the addresses and topics of logs, deriving
+// the bloom bits from hasher instead of keccak256. A nil hasher means keccak256.
+func (logs Logs) BloomWith(hasher func([]byte) libcommon.Hash) Bloom {
+	var bin Bloom
+	buf := make([]byte, 6)
+	logs.eachBloomInput(func(d []byte) bool {
+		i1, v1, i2, v2, i3, v3 := bloomValuesWith(d, hasher, buf)
+		bin[i1] |= v1
+		bin[i2] |= v2
+		bin[i3] |= v3
+		return true
+	})
+	return bin
+}
+
+// BloomBits returns the positions, in ascending order, of the bits set in the bloom
+// filter of logs. Bit n is the one selected by the 11-bit value n taken from a hash.
+// A nil hasher means keccak256.
+func (logs Logs) BloomBits(hasher func([]byte) libcommon.Hash) []uint {
+	bloom := logs.BloomWith(hasher)
+	var bits []uint
+	for i := BloomByteLength - 1; i >= 0; i-- {
+		for b := uint(0); b < 8; b++ {
+			if bloom[i]&(1<<b) != 0 {
+				bits = append(bits, uint(BloomByteLength-1-i)*8+b)
+			}
+		}
+	}
+	return bits
+}
+
+// BloomMatches reports whether the address and all topics of every log are set in
+// bloom. Like any bloom test it may report false positives. A nil hasher means
+// keccak256.
+func (logs Logs) BloomMatches(bloom Bloom, hasher func([]byte) libcommon.Hash) bool {
+	buf := make([]byte, 6)
+	return logs.eachBloomInput(func(d []byte) bool {
+		i1, v1, i2, v2, i3, v3 := bloomValuesWith(d, hasher, buf)
+		return bloom[i1]&v1 == v1 && bloom[i2]&v2 == v2 && bloom[i3]&v3 == v3
+	})
+}
+
+// eachBloomInput calls fn with the address and each topic of every log, the items
+// added to a bloom filter, and reports whether all calls returned true. It stops at
+// the first call returning false.
+func (logs Logs) eachBloomInput(fn func([]byte) bool) bool {
+	for _, log := range logs {
+		if !fn(log.Address[:]) {
+			return false
+		}
+		for _, topic := range log.Topics {
+			if !fn(topic[:]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bloomValuesWith is bloomValues with the hash function of data replaced by hasher,
+// falling back to keccak256 when hasher is nil.
+func bloomValuesWith(data []byte, hasher func([]byte) libcommon.Hash, hashbuf []byte) (uint, byte, uint, byte, uint, byte) {
+	if hasher == nil {
+		return bloomValues(data, hashbuf)
+	}
+	h := hasher(data)
+	return bloomHashValues(h[:])
+}
+
+// LogRun is a run of consecutive logs sharing the same address and first topic.
+type LogRun struct {
+	Log   *Log // first log of the run
+	Count int
+}
+
+// CoalesceRuns collapses consecutive logs with the same address and first topic
+// into runs, preserving the order of logs.
+func (logs Logs) CoalesceRuns() []LogRun {
+	var runs []LogRun
+	for _, l := range logs {
+		if n := len(runs); n > 0 && sameEvent(runs[n-1].Log, l) {
+			runs[n-1].Count++
+			continue
+		}
+		runs = append(runs, LogRun{Log: l, Count: 1})
+	}
+	return runs
+}
+
+// sameEvent reports whether a and b have the same address and first topic.
+func sameEvent(a, b *Log) bool {
+	if a.Address != b.Address || (len(a.Topics) == 0) != (len(b.Topics) == 0) {
+		return false
+	}
+	return len(a.Topics) == 0 || a.Topics[0] == b.Topics[0]
+}
+
+// Channel emits logs one by one on the returned channel from a new goroutine.
+// The channel is closed once all logs are sent or ctx is cancelled.
+func (logs Logs) Channel(ctx context.Context) <-chan *Log {
+	ch := make(chan *Log)
+	go func() {
+		defer close(ch)
+		for _, l := range logs {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case ch <- l:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// TopicToAddress extracts the address from an indexed address topic, which holds
+// the address left-padded with zeros. ok is false if the 12 padding bytes are not zero.
+func TopicToAddress(topic libcommon.Hash) (addr libcommon.Address, ok bool) {
+	const padding = length.Hash - length.Addr
+	for _, b := range topic[:padding] {
+		if b != 0 {
+			return addr, false
+		}
+	}
+	copy(addr[:], topic[padding:])
+	return addr, true
+}
+
+// AddressToTopic returns the topic representing addr as an indexed event parameter.
+func AddressToTopic(addr libcommon.Address) libcommon.Hash {
+	var topic libcommon.Hash
+	copy(topic[length.Hash-length.Addr:], addr[:])
+	return topic
+}
+
+// Signatures of the standard token events, the keccak256 of their canonical form.
+var (
+	// Transfer(address,address,uint256), shared by ERC-20 and ERC-721
+	TransferSig = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	// Approval(address,address,uint256), shared by ERC-20 and ERC-721
+	ApprovalSig = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	// ApprovalForAll(address,address,bool), shared by ERC-721 and ERC-1155
+	ApprovalForAllSig = libcommon.HexToHash("0x17307eab39ab6107e8899845ad3d59bd9653f200f220920489ca2b5937696c31")
+	// TransferSingle(address,address,address,uint256,uint256) of ERC-1155
+	TransferSingleSig = libcommon.HexToHash("0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62")
+	// TransferBatch(address,address,address,uint256[],uint256[]) of ERC-1155
+	TransferBatchSig = libcommon.HexToHash("0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb")
+)
+
+// IsERC20Transfer reports whether l is an ERC-20 Transfer: the signature and the
+// indexed from and to, with the amount in a single data word.
+func IsERC20Transfer(l *Log) bool {
+	return len(l.Topics) == 3 && l.Topics[0] == TransferSig && len(l.Data) == length.Hash
+}
+
+// IsERC721Transfer reports whether l is an ERC-721 Transfer: the signature and the
+// indexed from, to and token id, without data.
+func IsERC721Transfer(l *Log) bool {
+	return len(l.Topics) == 4 && l.Topics[0] == TransferSig && len(l.Data) == 0
+}
+
+// IsERC1155TransferSingle reports whether l is an ERC-1155 TransferSingle: the
+// signature, the indexed operator, sender and recipient, and the id and value in data.
+func IsERC1155TransferSingle(l *Log) bool {
+	return len(l.Topics) == 4 && l.Topics[0] == TransferSingleSig && len(l.Data) == 2*length.Hash
+}
+
+// IsERC1155TransferBatch reports whether l is an ERC-1155 TransferBatch: the signature,
+// the indexed operator, sender and recipient, and data holding the ABI encoding of the
+// ids and values arrays, at least their two offsets and two lengths.
+func IsERC1155TransferBatch(l *Log) bool {
+	return len(l.Topics) == 4 && l.Topics[0] == TransferBatchSig &&
+		len(l.Data) >= 4*length.Hash && len(l.Data)%length.Hash == 0
+}
+
+// TopicAsAddress returns the address held in the low 20 bytes of an indexed address
+// topic. Use TopicToAddress to also check the padding.
+func TopicAsAddress(t libcommon.Hash) libcommon.Address {
+	return libcommon.BytesToAddress(t[length.Hash-length.Addr:])
+}
+
+// TopicAsUint256 returns an indexed uint256 topic as a number.
+func TopicAsUint256(t libcommon.Hash) *uint256.Int {
+	return new(uint256.Int).SetBytes32(t[:])
+}
+
+// TopicAsBytes32 returns an indexed bytes32 topic as is.
+func TopicAsBytes32(t libcommon.Hash) [32]byte {
+	return t
+}
+
+// TopicKind is the ABI type of an indexed event parameter.
+type TopicKind uint8
+
+const (
+	TopicKindAddress TopicKind = iota
+	TopicKindUint256
+	TopicKindBytes32
+)
+
+func (k TopicKind) String() string {
+	switch k {
+	case TopicKindAddress:
+		return "address"
+	case TopicKindUint256:
+		return "uint256"
+	case TopicKindBytes32:
+		return "bytes32"
+	default:
+		return fmt.Sprintf("TopicKind(%d)", uint8(k))
+	}
+}
+
+// DecodeIndexed decodes topic i of the log as kind, returning a libcommon.Address,
+// a *uint256.Int or a [32]byte. Address topics with non-zero padding are rejected.
+func (l *Log) DecodeIndexed(i int, kind TopicKind) (any, error) {
+	if i < 0 || i >= len(l.Topics) {
+		return nil, fmt.Errorf("topic %d out of range, log has %d topics", i, len(l.Topics))
+	}
+	t := l.Topics[i]
+	switch kind {
+	case TopicKindAddress:
+		addr, ok := TopicToAddress(t)
+		if !ok {
+			return nil, fmt.Errorf("topic %d is not an address: non-zero padding in %x", i, t)
+		}
+		return addr, nil
+	case TopicKindUint256:
+		return TopicAsUint256(t), nil
+	case TopicKindBytes32:
+		return TopicAsBytes32(t), nil
+	default:
+		return nil, fmt.Errorf("unknown topic kind %s", kind)
+	}
+}
+
+// LogStats summarises a set of logs.
+type LogStats struct {
+	Count            int
+	UniqueAddresses  int
+	UniqueSignatures int // distinct first topics, logs without topics are not counted
+	TotalDataBytes   int
+	BlockSpan        [2]uint64 // lowest and highest block number, zero for no logs
+}
+
+// Stats computes the LogStats of logs in a single pass.
+func (logs Logs) Stats() LogStats {
+	stats := LogStats{Count: len(logs)}
+	if len(logs) == 0 {
+		return stats
+	}
+	addrs := make(map[libcommon.Address]struct{})
+	sigs := make(map[libcommon.Hash]struct{})
+	stats.BlockSpan = [2]uint64{logs[0].BlockNumber, logs[0].BlockNumber}
+	for _, l := range logs {
+		addrs[l.Address] = struct{}{}
+		if len(l.Topics) > 0 {
+			sigs[l.Topics[0]] = struct{}{}
+		}
+		stats.TotalDataBytes += len(l.Data)
+		stats.BlockSpan[0] = min(stats.BlockSpan[0], l.BlockNumber)
+		stats.BlockSpan[1] = max(stats.BlockSpan[1], l.BlockNumber)
+	}
+	stats.UniqueAddresses = len(addrs)
+	stats.UniqueSignatures = len(sigs)
+	return stats
+}
+
+// hllPrecision is the number of hash bits selecting a register of the HyperLogLog
+// sketch of ApproxUniqueAddresses, for 1<<hllPrecision one-byte registers.
+const hllPrecision = 10
+
+// ApproxUniqueAddresses estimates the number of distinct emitting addresses with a
+// HyperLogLog sketch of 1 KiB instead of a set of all addresses. The standard error
+// of the estimate is 1.04/sqrt(1024), about 3.3%, so it is within 10% of the exact
+// count in more than 99% of cases. Small counts are estimated by linear counting and
+// are close to exact.
+func (logs Logs) ApproxUniqueAddresses() uint64 {
+	const m = 1 << hllPrecision
+	var registers [m]uint8
+	h := fnv.New64a()
+	for _, l := range logs {
+		h.Reset()
+		h.Write(l.Address[:])
+		x := mix64(h.Sum64())
+		rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+		idx := x >> (64 - hllPrecision)
+		registers[idx] = max(registers[idx], rank)
+	}
+	var sum float64
+	var zeros int
+	for _, r := range registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(float64(m)/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// mix64 is the splitmix64 finalizer, spreading the bits of FNV hashes of similar
+// inputs over the whole word.
+func mix64(z uint64) uint64 {
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// FilterPred returns the logs satisfying pred. maxLogs limits the number of returned
+// logs, zero means no limit. Predicates are built with ByAddress, ByTopicAt, ByDataLen
+// and combined with AndFilters, OrFilters and NotFilter.
+func (logs Logs) FilterPred(pred func(*Log) bool, maxLogs uint64) Logs {
+	o := make(Logs, 0, len(logs))
+	for _, v := range logs {
+		if !pred(v) {
+			continue
+		}
+		o = append(o, v)
+		if maxLogs != 0 && uint64(len(o)) >= maxLogs {
+			break
+		}
+	}
+	return o
+}
+
+// AndFilters returns a predicate satisfied when all fs are. No predicates match everything.
+func AndFilters(fs ...func(*Log) bool) func(*Log) bool {
+	return func(l *Log) bool {
+		for _, f := range fs {
+			if !f(l) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// OrFilters returns a predicate satisfied when any of fs is. No predicates match nothing.
+func OrFilters(fs ...func(*Log) bool) func(*Log) bool {
+	return func(l *Log) bool {
+		for _, f := range fs {
+			if f(l) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NotFilter returns the negation of f.
+func NotFilter(f func(*Log) bool) func(*Log) bool {
+	return func(l *Log) bool {
+		return !f(l)
+	}
+}
+
+// ByAddress returns a predicate matching logs emitted by one of addrMap. An empty
+// addrMap matches any address.
+func ByAddress(addrMap map[libcommon.Address]struct{}) func(*Log) bool {
+	return func(l *Log) bool {
+		if len(addrMap) == 0 {
+			return true
+		}
+		_, ok := addrMap[l.Address]
+		return ok
+	}
+}
+
+// ByTopicAt returns a predicate matching logs whose topic at pos equals topic.
+func ByTopicAt(pos int, topic libcommon.Hash) func(*Log) bool {
+	return func(l *Log) bool {
+		return pos >= 0 && pos < len(l.Topics) && l.Topics[pos] == topic
+	}
+}
+
+// ByDataLen returns a predicate matching logs whose data length is within
+// [minLen, maxLen]. A negative maxLen means no upper bound.
+func ByDataLen(minLen, maxLen int) func(*Log) bool {
+	return func(l *Log) bool {
+		return len(l.Data) >= minLen && (maxLen < 0 || len(l.Data) <= maxLen)
+	}
+}
+
+// CompiledFilter is an address/topic filter prepared once and reused across many
+// blocks: lookups are backed by maps and the bloom bits of every address and topic
+// are precomputed, so per-block bloom checks don't hash anything.
+// A CompiledFilter is immutable and safe for concurrent use.
+type CompiledFilter struct {
+	addrMap  map[libcommon.Address]struct{}
+	topicMap []map[libcommon.Hash]struct{} // nil entry means wildcard
+
+	addrBits  []bloomBits
+	topicBits [][]bloomBits // nil entry means wildcard
+}
+
+// bloomBits holds the three byte positions and masks an item sets in a Bloom.
+type bloomBits struct {
+	i1, i2, i3 uint
+	v1, v2, v3 byte
+}
+
+func newBloomBits(d []byte, buf []byte) bloomBits {
+	i1, v1, i2, v2, i3, v3 := bloomValues(d, buf)
+	return bloomBits{i1: i1, i2: i2, i3: i3, v1: v1, v2: v2, v3: v3}
+}
+
+func (bb bloomBits) in(b *Bloom) bool {
+	return bb.v1 == bb.v1&b[bb.i1] &&
+		bb.v2 == bb.v2&b[bb.i2] &&
+		bb.v3 == bb.v3&b[bb.i3]
+}
+
+// CompileFilter prepares a CompiledFilter with the same matching semantics as Logs.Filter.
+func CompileFilter(addrMap map[libcommon.Address]struct{}, topics [][]libcommon.Hash) *CompiledFilter {
+	buf := make([]byte, 6)
+	cf := &CompiledFilter{
+		addrMap:   addrMap,
+		topicMap:  make([]map[libcommon.Hash]struct{}, len(topics)),
+		topicBits: make([][]bloomBits, len(topics)),
+	}
+	for addr := range addrMap {
+		cf.addrBits = append(cf.addrBits, newBloomBits(addr[:], buf))
+	}
+	for idx, sub := range topics {
+		if len(sub) == 0 {
+			continue
+		}
+		cf.topicMap[idx] = make(map[libcommon.Hash]struct{}, len(sub))
+		for _, topic := range sub {
+			if _, ok := cf.topicMap[idx][topic]; ok {
+				continue
+			}
+			cf.topicMap[idx][topic] = struct{}{}
+			cf.topicBits[idx] = append(cf.topicBits[idx], newBloomBits(topic[:], buf))
+		}
+	}
+	return cf
+}
+
+// Match reports whether l satisfies the filter.
+func (cf *CompiledFilter) Match(l *Log) bool {
+	if len(cf.addrMap) != 0 {
+		if _, ok := cf.addrMap[l.Address]; !ok {
+			return false
+		}
+	}
+	if len(cf.topicMap) > len(l.Topics) {
+		return false
+	}
+	for idx, topicSet := range cf.topicMap {
+		if topicSet == nil {
+			continue
+		}
+		if _, ok := topicSet[l.Topics[idx]]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// BloomMatches reports whether a block with the given bloom may contain matching logs.
+// False positives are possible, false negatives are not.
+func (cf *CompiledFilter) BloomMatches(bloom *Bloom) bool {
+	if len(cf.addrBits) != 0 && !anyBloomBits(cf.addrBits, bloom) {
+		return false
+	}
+	for _, sub := range cf.topicBits {
+		if sub != nil && !anyBloomBits(sub, bloom) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyBloomBits(bits []bloomBits, bloom *Bloom) bool {
+	for _, bb := range bits {
+		if bb.in(bloom) {
+			return true
+		}
+	}
+	return false
+}
+
+// BlockLogs are the logs of a single block together with the block's logs bloom.
+type BlockLogs struct {
+	Bloom Bloom
+	Logs  Logs
+}
+
+// ApplyBlocks returns the logs of blocks matching the filter, skipping blocks whose
+// bloom rules out a match. maxLogs limits the number of matches across all blocks,
+// zero means no limit.
+func (cf *CompiledFilter) ApplyBlocks(blocks []BlockLogs, maxLogs uint64) Logs {
+	var o Logs
+	for i := range blocks {
+		if !cf.BloomMatches(&blocks[i].Bloom) {
+			continue
+		}
+		for _, l := range blocks[i].Logs {
+			if !cf.Match(l) {
+				continue
+			}
+			o = append(o, l)
+			if maxLogs != 0 && uint64(len(o)) >= maxLogs {
+				return o
+			}
+		}
+	}
+	return o
+}
+
+// SignatureRegistry maps event signatures, the first topic of a log, to event names.
+// The zero value is an empty registry ready to use. It is safe for concurrent use.
+type SignatureRegistry struct {
+	mu    sync.RWMutex
+	names map[libcommon.Hash]string
+}
+
+// AnnotatedLog is a log together with the name of its event, empty if unknown.
+type AnnotatedLog struct {
+	Log  *Log
+	Name string
+}
+
+// Register associates sig with name, replacing any previous name.
+func (r *SignatureRegistry) Register(sig libcommon.Hash, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names == nil {
+		r.names = map[libcommon.Hash]string{}
+	}
+	r.names[sig] = name
+}
+
+// Annotate pairs each log with the registered name of its signature.
+func (r *SignatureRegistry) Annotate(logs Logs) []AnnotatedLog {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	o := make([]AnnotatedLog, len(logs))
+	for i, l := range logs {
+		o[i].Log = l
+		if len(l.Topics) > 0 {
+			o[i].Name = r.names[l.Topics[0]]
+		}
+	}
+	return o
+}
+
+// annotatedLogJSON is the JSON form of a log, as produced by Log.MarshalJSON, with
+// the name of its event added.
+type annotatedLogJSON struct {
+	deterministicLog
+	EventName string `json:"eventName,omitempty"`
+}
+
+// MarshalJSONAnnotated encodes logs as a JSON array of logs in their usual form, each
+// with an extra eventName field holding the name registered for its signature.
+// The field is omitted for logs of unknown signatures. A nil registry knows no
+// names, the logs are then encoded as by json.Marshal.
+func (logs Logs) MarshalJSONAnnotated(registry *SignatureRegistry) ([]byte, error) {
+	if registry == nil {
+		return json.Marshal(logs)
+	}
+	annotated := registry.Annotate(logs)
+	o := make([]annotatedLogJSON, len(annotated))
+	for i, a := range annotated {
+		o[i] = annotatedLogJSON{newDeterministicLog(a.Log), a.Name}
+	}
+	return json.Marshal(o)
+}
+
+// DecoratedLog carries metadata alongside a log, such as decoded values, leaving
+// the log itself untouched.
+type DecoratedLog struct {
+	*Log
+	Meta map[string]any
+}
+
+// Decorate wraps each log in a DecoratedLog with empty metadata.
+func Decorate(logs Logs) []*DecoratedLog {
+	o := make([]*DecoratedLog, len(logs))
+	for i, l := range logs {
+		o[i] = &DecoratedLog{Log: l, Meta: map[string]any{}}
+	}
+	return o
+}
+
+// Set stores val under key in the metadata.
+func (d *DecoratedLog) Set(key string, val any) {
+	if d.Meta == nil {
+		d.Meta = map[string]any{}
+	}
+	d.Meta[key] = val
+}
+
+// SourcedLog is a log together with the sources that reported it.
+type SourcedLog struct {
+	Log     *Log
+	Source  string   // first source reporting the log, by name
+	Sources []string // every source reporting the log, sorted by name
+}
+
+// MergeLogsWithSource merges the logs reported by several sources into one slice in
+// canonical order, keeping track of where each log came from. Logs reported by more
+// than one source, the same (BlockHash, TxHash, Index), are merged into a single
+// entry holding the log of the first source.
+func MergeLogsWithSource(sources map[string]Logs) []SourcedLog {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var o []SourcedLog
+	seen := map[logKey]int{} // position in o
+	for _, name := range names {
+		for _, l := range sources[name] {
+			key := keyOf(l)
+			if pos, ok := seen[key]; ok {
+				if last := o[pos].Sources[len(o[pos].Sources)-1]; last != name {
+					o[pos].Sources = append(o[pos].Sources, name)
+				}
+				continue
+			}
+			seen[key] = len(o)
+			o = append(o, SourcedLog{Log: l, Source: name, Sources: []string{name}})
+		}
+	}
+	slices.SortStableFunc(o, func(a, b SourcedLog) int { return compareLogsCanonical(a.Log, b.Log) })
+	return o
+}
+
+// RecentLogs keeps the most recent logs in a fixed-size ring buffer.
+// Once the buffer is full, adding a log evicts the oldest one.
+// It is safe for concurrent use.
+type RecentLogs struct {
+	mu    sync.Mutex
+	buf   Logs
+	start int // position of the oldest log
+	size  int
+}
+
+// NewRecentLogs creates a RecentLogs buffer holding at most capacity logs.
+func NewRecentLogs(capacity int) *RecentLogs {
+	if capacity <= 0 {
+		panic(fmt.Sprintf("invalid RecentLogs capacity %d", capacity))
+	}
+	return &RecentLogs{buf: make(Logs, capacity)}
+}
+
+// Add appends l to the buffer, evicting the oldest log if the buffer is full.
+func (r *RecentLogs) Add(l *Log) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size < len(r.buf) {
+		r.buf[(r.start+r.size)%len(r.buf)] = l
+		r.size++
+		return
+	}
+	r.buf[r.start] = l
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// Snapshot returns the buffered logs ordered from oldest to newest.
+func (r *RecentLogs) Snapshot() Logs {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o := make(Logs, r.size)
+	for i := 0; i < r.size; i++ {
+		o[i] = r.buf[(r.start+i)%len(r.buf)]
+	}
+	return o
+}
+
+// ConcurrentLogs collects logs appended from several goroutines.
+// The zero value is empty and ready to use. It is safe for concurrent use.
+type ConcurrentLogs struct {
+	mu   sync.Mutex
+	logs Logs
+}
+
+// Append adds l to the collected logs.
+func (c *ConcurrentLogs) Append(l *Log) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = append(c.logs, l)
+}
+
+// AppendBatch adds logs to the collected logs, keeping them contiguous.
+func (c *ConcurrentLogs) AppendBatch(logs Logs) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = append(c.logs, logs...)
+}
+
+// Drain returns the collected logs and empties the collection.
+func (c *ConcurrentLogs) Drain() Logs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	o := c.logs
+	c.logs = nil
+	return o
+}
+
+type logMarshaling struct {
+	Data        hexutility.Bytes
+	BlockNumber hexutil.Uint64
+	TxIndex     hexutil.Uint
+	Index       hexutil.Uint
+}
+
+var (
+	ErrLogTruncated     = errors.New("log rlp: truncated input")
+	ErrTooManyTopics    = errors.New("log rlp: too many topics")
+	ErrMalformedTopic   = errors.New("log rlp: malformed topic")
+	ErrMalformedAddress = errors.New("log rlp: malformed address")
+)
+
+// maxLogTopics is the number of topics of the widest LOG opcode, LOG4.
+const maxLogTopics = 4
+
+type rlpLog struct {
+	Address libcommon.Address
+	Topics  []libcommon.Hash
+	Data    []byte
+}
+
+// rlpErigonLog is the encoding of an ErigonLog: the consensus fields of the log and its block timestamp.
+type rlpErigonLog struct {
+	Address   libcommon.Address
+	Topics    []libcommon.Hash
+	Data      []byte
+	Timestamp uint64
+}
+
+// rlpStorageLog is the storage encoding of a log.
+type rlpStorageLog rlpLog
+
+// legacyRlpStorageLog is the previous storage encoding of a log including some redundant fields.
+// With those fields dropped its layout is that of rlpStorageLog.
+type legacyRlpStorageLog struct {
+	Address libcommon.Address
+	Topics  []libcommon.Hash
+	Data    []byte
+	//BlockNumber uint64
+	//TxHash      libcommon.Hash
+	//TxIndex     uint
+	//BlockHash   libcommon.Hash
+	//Index       uint
+}
+
+// EncodeRLP implements rlp.Encoder.
+func (l *Log) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, rlpLog{Address: l.Address, Topics: l.Topics, Data: l.Data})
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (l *Log) DecodeRLP(s *rlp.Stream) error {
+	var dec rlpLog
+	err := decodeRlpLog(s, &dec)
+	if err == nil {
+		l.Address, l.Topics, l.Data = dec.Address, dec.Topics, dec.Data
+	}
+	return err
+}
+
+// decodeRlpLog decodes the consensus fields of a log. Failures are wrapped into
+// ErrLogTruncated, ErrTooManyTopics, ErrMalformedTopic or ErrMalformedAddress where
+// they apply. The address must be exactly 20 bytes and every topic exactly 32.
+func decodeRlpLog(s *rlp.Stream, dec *rlpLog) error {
+	if _, err := s.List(); err != nil {
+		return wrapLogDecodeErr(err)
+	}
+	addr, err := s.Bytes()
+	if err != nil {
+		if errors.Is(err, rlp.ErrExpectedString) || errors.Is(err, rlp.ErrCanonSize) {
+			return fmt.Errorf("%w: %w", ErrMalformedAddress, err)
+		}
+		return wrapLogDecodeErr(err)
+	}
+	if len(addr) != length.Addr {
+		return fmt.Errorf("%w: length %d, expected %d", ErrMalformedAddress, len(addr), length.Addr)
+	}
+	dec.Address = libcommon.BytesToAddress(addr)
+	if _, err := s.List(); err != nil {
+		return wrapLogDecodeErr(err)
+	}
+	dec.Topics = []libcommon.Hash{}
+	for {
+		b, err := s.Bytes()
+		if errors.Is(err, rlp.EOL) {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, rlp.ErrExpectedString) || errors.Is(err, rlp.ErrCanonSize) {
+				return fmt.Errorf("%w %d: %w", ErrMalformedTopic, len(dec.Topics), err)
+			}
+			return wrapLogDecodeErr(err)
+		}
+		if len(b) != length.Hash {
+			return fmt.Errorf("%w %d: length %d, expected %d", ErrMalformedTopic, len(dec.Topics), len(b), length.Hash)
+		}
+		if len(dec.Topics) == maxLogTopics {
+			return fmt.Errorf("%w: more than %d", ErrTooManyTopics, maxLogTopics)
+		}
+		dec.Topics = append(dec.Topics, libcommon.BytesToHash(b))
+	}
+	if err := s.ListEnd(); err != nil {
+		return wrapLogDecodeErr(err)
+	}
+	if dec.Data, err = s.Bytes(); err != nil {
+		return wrapLogDecodeErr(err)
+	}
+	return wrapLogDecodeErr(s.ListEnd())
+}
+
+func wrapLogDecodeErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, rlp.ErrValueTooLarge), errors.Is(err, rlp.ErrElemTooLarge):
+		return fmt.Errorf("%w: %w", ErrLogTruncated, err)
+	default:
+		return err
+	}
+}
+
+// EncodeRLP implements rlp.Encoder. Unlike Log, the encoding includes the Timestamp.
+func (l *ErigonLog) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, rlpErigonLog{Address: l.Address, Topics: l.Topics, Data: l.Data, Timestamp: l.Timestamp})
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (l *ErigonLog) DecodeRLP(s *rlp.Stream) error {
+	var dec rlpErigonLog
+	err := s.Decode(&dec)
+	if err == nil {
+		l.Address, l.Topics, l.Data, l.Timestamp = dec.Address, dec.Topics, dec.Data, dec.Timestamp
+	}
+	return err
+}
+
+// Copy creates a deep copy of the Log.
+func (l *Log) Copy() *Log {
+	if l == nil {
+		return nil
+	}
+	return &Log{
+		Address:     libcommon.BytesToAddress(l.Address.Bytes()),
+		Topics:      slices.Clone(l.Topics),
+		Data:        slices.Clone(l.Data),
+		BlockNumber: l.BlockNumber,
+		TxHash:      libcommon.BytesToHash(l.TxHash.Bytes()),
+		TxIndex:     l.TxIndex,
+		BlockHash:   libcommon.BytesToHash(l.BlockHash.Bytes()),
+		Index:       l.Index,
+		Removed:     l.Removed,
+		TxSuccess:   copyBoolPtr(l.TxSuccess),
+	}
+}
+
+// Copy returns a deep copy of the log, timestamp included, sharing no memory with it.
+func (l *ErigonLog) Copy() *ErigonLog {
+	if l == nil {
+		return nil
+	}
+	return &ErigonLog{
+		Address:     l.Address,
+		Topics:      slices.Clone(l.Topics),
+		Data:        slices.Clone(l.Data),
+		BlockNumber: l.BlockNumber,
+		TxHash:      l.TxHash,
+		TxIndex:     l.TxIndex,
+		BlockHash:   l.BlockHash,
+		Index:       l.Index,
+		Removed:     l.Removed,
+		Timestamp:   l.Timestamp,
+		TxSuccess:   copyBoolPtr(l.TxSuccess),
+	}
+}
+
+// CopyBounded works like Copy but fails with ErrTooManyTopics instead of copying a
+// log carrying more than maxTopics topics. Use it on logs from untrusted sources.
+func (l *Log) CopyBounded(maxTopics int) (*Log, error) {
+	if l != nil && len(l.Topics) > maxTopics {
+		return nil, fmt.Errorf("%w: %d, limit %d", ErrTooManyTopics, len(l.Topics), maxTopics)
+	}
+	return l.Copy(), nil
+}
+
+func copyBoolPtr(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	return &v
+}
+
+// Succeeded reports whether the transaction which emitted the log succeeded.
+// Logs without TxSuccess set are considered successful.
+func (l *Log) Succeeded() bool {
+	return l.TxSuccess == nil || *l.TxSuccess
+}
+
+// Bucket assigns the log to one of n buckets from its address and signature, e.g. to
+// group logs into lanes of a visualisation. The bucket is h mod n, where h is the
+// 64-bit FNV-1a hash of the address followed by the first topic, or the zero hash
+// for anonymous logs, passed through the splitmix64 finalizer. It is stable across
+// runs and releases. Bucket panics if n is not positive.
+func (l *Log) Bucket(n int) int {
+	if n <= 0 {
+		panic(fmt.Sprintf("invalid bucket count %d", n))
+	}
+	var sig libcommon.Hash
+	if len(l.Topics) > 0 {
+		sig = l.Topics[0]
+	}
+	h := fnv.New64a()
+	h.Write(l.Address[:]) //nolint:errcheck
+	h.Write(sig[:])       //nolint:errcheck
+	return int(mix64(h.Sum64()) % uint64(n))
+}
+
+// TopicsChecksum returns a 64-bit FNV-1a hash of the concatenated topics, meant for
+// cheap detection of accidental corruption of stored logs.
+// It is not cryptographically secure and must not be used to detect tampering.
+func (l *Log) TopicsChecksum() uint64 {
+	h := fnv.New64a()
+	for _, topic := range l.Topics {
+		h.Write(topic[:]) //nolint:errcheck
+	}
+	return h.Sum64()
+}
+
+// deterministicLog fixes the field order of MarshalJSONDeterministic; don't reorder.
+// It is also the wire shape of logs in MarshalJSONAnnotated.
+type deterministicLog struct {
+	Address     libcommon.Address `json:"address"`
+	Topics      []libcommon.Hash  `json:"topics"`
+	Data        hexutility.Bytes  `json:"data"`
+	BlockNumber hexutil.Uint64    `json:"blockNumber"`
+	TxHash      libcommon.Hash    `json:"transactionHash"`
+	TxIndex     hexutil.Uint      `json:"transactionIndex"`
+	BlockHash   libcommon.Hash    `json:"blockHash"`
+	Index       hexutil.Uint      `json:"logIndex"`
+	Removed     bool              `json:"removed"`
+}
+
+func newDeterministicLog(l *Log) deterministicLog {
+	return deterministicLog{
+		Address:     l.Address,
+		Topics:      l.Topics,
+		Data:        l.Data,
+		BlockNumber: hexutil.Uint64(l.BlockNumber),
+		TxHash:      l.TxHash,
+		TxIndex:     hexutil.Uint(l.TxIndex),
+		BlockHash:   l.BlockHash,
+		Index:       hexutil.Uint(l.Index),
+		Removed:     l.Removed,
+	}
+}
+
+// MarshalJSONDeterministic encodes the log as JSON with the fields in the order
+// address, topics, data, blockNumber, transactionHash, transactionIndex, blockHash,
+// logIndex, removed. Nil and empty topics both encode as [], so the output only
+// depends on the log's values, which makes it suitable for golden files.
+func (l *Log) MarshalJSONDeterministic() ([]byte, error) {
+	d := newDeterministicLog(l)
+	if d.Topics == nil {
+		d.Topics = []libcommon.Hash{}
+	}
+	return json.Marshal(d)
+}
+
+// TopicsMultiset returns the number of occurrences of each topic of the log,
+// regardless of position.
+func (l *Log) TopicsMultiset() map[libcommon.Hash]int {
+	o := make(map[libcommon.Hash]int, len(l.Topics))
+	for _, topic := range l.Topics {
+		o[topic]++
+	}
+	return o
+}
+
+// MatchPositions returns the topic positions of the log matching the corresponding
+// set of a positional topic filter, as used by Logs.Filter. Wildcard positions and
+// positions beyond the topics of the log are never reported.
+func (l *Log) MatchPositions(topics [][]libcommon.Hash) []int {
+	var o []int
+	for i, set := range topics {
+		if i >= len(l.Topics) {
+			break
+		}
+		if slices.Contains(set, l.Topics[i]) {
+			o = append(o, i)
+		}
+	}
+	return o
+}
+
+// ToRow flattens the log into a row for columnar stores. Hashes, the address and
+// the data are lowercase 0x-prefixed hex strings; topic0 to topic3 are nil when the
+// log has fewer topics.
+func (l *Log) ToRow() map[string]any {
+	row := map[string]any{
+		"address":      hexutility.Encode(l.Address[:]),
+		"data":         hexutility.Encode(l.Data),
+		"block_number": l.BlockNumber,
+		"tx_hash":      hexutility.Encode(l.TxHash[:]),
+		"tx_index":     l.TxIndex,
+		"log_index":    l.Index,
+		"removed":      l.Removed,
+	}
+	for i := 0; i < maxLogTopics; i++ {
+		var topic any
+		if i < len(l.Topics) {
+			topic = hexutility.Encode(l.Topics[i][:])
+		}
+		row[fmt.Sprintf("topic%d", i)] = topic
+	}
+	return row
+}
+
+// ToRows flattens logs into rows with Log.ToRow.
+func (logs Logs) ToRows() []map[string]any {
+	rows := make([]map[string]any, len(logs))
+	for i, l := range logs {
+		rows[i] = l.ToRow()
+	}
+	return rows
+}
+
+var csvHeader = []string{"block_number", "tx_hash", "tx_index", "log_index", "address", "topic0", "topic1", "topic2", "topic3", "data", "removed"}
+
+// WriteCSV writes logs as CSV: a header row, then one row per log with the fields of
+// Log.ToRow. Missing topics are written as empty fields.
+func (logs Logs) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	record := make([]string, len(csvHeader))
+	for _, l := range logs {
+		record = append(record[:0],
+			strconv.FormatUint(l.BlockNumber, 10),
+			hexutility.Encode(l.TxHash[:]),
+			strconv.FormatUint(uint64(l.TxIndex), 10),
+			strconv.FormatUint(uint64(l.Index), 10),
+			hexutility.Encode(l.Address[:]),
+		)
+		for i := 0; i < maxLogTopics; i++ {
+			var topic string
+			if i < len(l.Topics) {
+				topic = hexutility.Encode(l.Topics[i][:])
+			}
+			record = append(record, topic)
+		}
+		record = append(record, hexutility.Encode(l.Data), strconv.FormatBool(l.Removed))
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// DataHash returns the keccak256 hash of the log data.
+func (l *Log) DataHash() libcommon.Hash {
+	return crypto.Keccak256Hash(l.Data)
+}
+
+// ContentHash returns the keccak256 hash of the RLP encoding of the consensus fields
+// of the log, (Address, Topics, Data). Derived fields are left out, so a log hashes
+// the same whatever block, transaction or position it is found at.
+func (l *Log) ContentHash() libcommon.Hash {
+	return rlpHash(rlpLog{Address: l.Address, Topics: l.Topics, Data: l.Data})
+}
+
+// DataWord returns the i-th 32-byte word of the log data. It reports false if i is
+// negative or the data doesn't hold a complete word at that offset.
+func (l *Log) DataWord(i int) (libcommon.Hash, bool) {
+	if i < 0 || i >= len(l.Data)/length.Hash {
+		return libcommon.Hash{}, false
+	}
+	return libcommon.BytesToHash(l.Data[i*length.Hash : (i+1)*length.Hash]), true
+}
+
+// CanonicalID returns an identifier referencing the log by its position in the chain.
+// The format is "<blockHash>-<txIndex>-<logIndex>", where the block hash is a
+// 0x-prefixed 32-byte hex string and both indices are 0x-prefixed hex quantities
+// without leading zeros, e.g. "0x656c...1056-0x3-0x2". The result is URL-safe.
+func (l *Log) CanonicalID() string {
+	return l.BlockHash.Hex() + "-" + hexutil.EncodeUint64(uint64(l.TxIndex)) + "-" + hexutil.EncodeUint64(uint64(l.Index))
+}
+
+// ParseCanonicalID parses an identifier produced by Log.CanonicalID.
+func ParseCanonicalID(id string) (blockHash libcommon.Hash, txIndex uint, logIndex uint, err error) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 3 {
+		return blockHash, 0, 0, fmt.Errorf("invalid log id %q: expected 3 parts, got %d", id, len(parts))
+	}
+	if err = blockHash.UnmarshalText([]byte(parts[0])); err != nil {
+		return libcommon.Hash{}, 0, 0, fmt.Errorf("invalid log id %q: block hash: %w", id, err)
+	}
+	txIndex, err = parseCanonicalIDIndex(parts[1])
+	if err != nil {
+		return libcommon.Hash{}, 0, 0, fmt.Errorf("invalid log id %q: transaction index: %w", id, err)
+	}
+	logIndex, err = parseCanonicalIDIndex(parts[2])
+	if err != nil {
+		return libcommon.Hash{}, 0, 0, fmt.Errorf("invalid log id %q: log index: %w", id, err)
+	}
+	return blockHash, txIndex, logIndex, nil
+}
+
+func parseCanonicalIDIndex(s string) (uint, error) {
+	v, err := hexutil.DecodeUint64(s)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(uint(v)) != v {
+		return 0, fmt.Errorf("index %d overflows uint", v)
+	}
+	return uint(v), nil
+}
+
+// LogFromHexRLP decodes the consensus fields of a log from the hex string of its RLP
+// encoding. The 0x prefix is optional.
+func LogFromHexRLP(hexStr string) (*Log, error) {
+	if hexutility.Has0xPrefix(hexStr) {
+		hexStr = hexStr[2:]
+	}
+	b, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log hex: %w", err)
+	}
+	l := new(Log)
+	if err := rlp.DecodeBytes(b, l); err != nil {
+		return nil, fmt.Errorf("invalid log rlp: %w", err)
+	}
+	return l, nil
+}
+
+// ToHexRLP returns the 0x-prefixed hex string of the RLP encoding of the consensus
+// fields of the log. It is the inverse of LogFromHexRLP.
+func (l *Log) ToHexRLP() string {
+	enc, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		// encoding a fixed-size address, hashes and a byte slice can't fail
+		panic(err)
+	}
+	return hexutility.Encode(enc)
+}
+
+// EncodeEventLogArgs returns the log in the layout of the LOG opcode operands:
+// every topic as a 32-byte word and a copy of the data that would be read from memory.
+func (l *Log) EncodeEventLogArgs() (topics [][32]byte, data []byte) {
+	topics = make([][32]byte, len(l.Topics))
+	for i, topic := range l.Topics {
+		topics[i] = topic
+	}
+	return topics, slices.Clone(l.Data)
+}
+
+// ToProto converts the log, including derived fields, into its gRPC representation.
+// TxSuccess is not carried, it is only set when replaying speculative executions.
+func (l *Log) ToProto() *remote.SubscribeLogsReply {
+	p := &remote.SubscribeLogsReply{
+		Address:          gointerfaces.ConvertAddressToH160(l.Address),
+		BlockHash:        gointerfaces.ConvertHashToH256(l.BlockHash),
+		BlockNumber:      l.BlockNumber,
+		Data:             l.Data,
+		LogIndex:         uint64(l.Index),
+		Topics:           make([]*typesproto.H256, 0, len(l.Topics)),
+		TransactionHash:  gointerfaces.ConvertHashToH256(l.TxHash),
+		TransactionIndex: uint64(l.TxIndex),
+		Removed:          l.Removed,
+	}
+	for _, topic := range l.Topics {
+		p.Topics = append(p.Topics, gointerfaces.ConvertHashToH256(topic))
+	}
+	return p
+}
+
+// LogFromProto converts the gRPC representation of a log back into a Log.
+func LogFromProto(p *remote.SubscribeLogsReply) *Log {
+	if p == nil {
+		return nil
+	}
+	l := &Log{
+		Address:     gointerfaces.ConvertH160toAddress(p.Address),
+		Topics:      make([]libcommon.Hash, 0, len(p.Topics)),
+		Data:        p.Data,
+		BlockNumber: p.BlockNumber,
+		TxHash:      gointerfaces.ConvertH256ToHash(p.TransactionHash),
+		TxIndex:     uint(p.TransactionIndex),
+		BlockHash:   gointerfaces.ConvertH256ToHash(p.BlockHash),
+		Index:       uint(p.LogIndex),
+		Removed:     p.Removed,
+	}
+	for _, topic := range p.Topics {
+		l.Topics = append(l.Topics, gointerfaces.ConvertH256ToHash(topic))
+	}
+	return l
+}
+
+// LogForStorage is a wrapper around a Log that flattens and parses the entire content of
+// a log including non-consensus fields.
+type LogForStorage Log
+
+// EncodeRLP implements rlp.Encoder.
+func (l *LogForStorage) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, rlpStorageLog{
+		Address: l.Address,
+		Topics:  l.Topics,
+		Data:    l.Data,
+		//BlockNumber: l.BlockNumber,
+		//TxHash:      l.TxHash,
+		//TxIndex:     l.TxIndex,
+		//BlockHash:   l.BlockHash,
+		//Index:       l.Index,
+	})
+}
+
+// DecodeRLP implements rlp.Decoder. Logs stored in the legacy format decode alike,
+// as it has the same layout.
+//
+// Note some redundant fields(e.g. block number, txn hash etc) will be assembled later.
+func (l *LogForStorage) DecodeRLP(s *rlp.Stream) error {
+	var dec rlpLog
+	if err := decodeRlpLog(s, &dec); err != nil {
+		return err
+	}
+	*l = LogForStorage{
+		Address: dec.Address,
+		Topics:  dec.Topics,
+		Data:    dec.Data,
+	}
+	return nil
+}
+
+// VerifyLogStorageRoundTrip decodes a stored log and reports whether re-encoding it
+// reproduces blob exactly. The legacy storage format is byte-identical to the current
+// one, its redundant fields having been dropped, so one comparison covers both.
+func VerifyLogStorageRoundTrip(blob []byte) (bool, error) {
+	var l LogForStorage
+	if err := rlp.DecodeBytes(blob, &l); err != nil {
+		return false, err
+	}
+	enc, err := rlp.EncodeToBytes(&l)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(enc, blob), nil
+}
+
+var ErrMalformedCompactLog = errors.New("malformed compact log")
+
+// EncodeCompact encodes the consensus fields of the log in a compact form that
+// spends no space on zero topics: a header byte holding the topic count in bits 4-6
+// and a bitmask of the non-zero topics in bits 0-3, the address, the non-zero topics
+// and the data prefixed with its uvarint length. It panics if the log has more than
+// four topics, which no valid log has.
+func (l *Log) EncodeCompact() []byte {
+	if len(l.Topics) > maxLogTopics {
+		panic(fmt.Sprintf("compact log encoding: %d topics, at most %d supported", len(l.Topics), maxLogTopics))
+	}
+	header := byte(len(l.Topics)) << 4
+	size := 1 + length.Addr + binary.MaxVarintLen64 + len(l.Data)
+	for i, topic := range l.Topics {
+		if topic != (libcommon.Hash{}) {
+			header |= 1 << i
+			size += length.Hash
+		}
+	}
+	b := make([]byte, 0, size)
+	b = append(b, header)
+	b = append(b, l.Address[:]...)
+	for _, topic := range l.Topics {
+		if topic != (libcommon.Hash{}) {
+			b = append(b, topic[:]...)
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(l.Data)))
+	return append(b, l.Data...)
+}
+
+// DecodeCompact decodes a log encoded with EncodeCompact. Short input fails with
+// ErrLogTruncated, an invalid header or trailing bytes with ErrMalformedCompactLog.
+func DecodeCompact(b []byte) (*Log, error) {
+	if len(b) < 1+length.Addr {
+		return nil, ErrLogTruncated
+	}
+	header := b[0]
+	count := int(header >> 4)
+	if count > maxLogTopics || int(header&0x0f)>>count != 0 {
+		return nil, fmt.Errorf("%w: header %#02x", ErrMalformedCompactLog, header)
+	}
+	l := &Log{Address: libcommon.BytesToAddress(b[1 : 1+length.Addr])}
+	b = b[1+length.Addr:]
+	if count > 0 {
+		l.Topics = make([]libcommon.Hash, count)
+	}
+	for i := range l.Topics {
+		if header&(1<<i) == 0 {
+			continue
+		}
+		if len(b) < length.Hash {
+			return nil, ErrLogTruncated
+		}
+		l.Topics[i] = libcommon.BytesToHash(b[:length.Hash])
+		b = b[length.Hash:]
+	}
+	dataLen, n := binary.Uvarint(b)
+	switch {
+	case n == 0:
+		return nil, ErrLogTruncated
+	case n < 0:
+		return nil, fmt.Errorf("%w: data length overflows", ErrMalformedCompactLog)
+	}
+	b = b[n:]
+	if uint64(len(b)) < dataLen {
+		return nil, ErrLogTruncated
+	}
+	if uint64(len(b)) > dataLen {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrMalformedCompactLog, uint64(len(b))-dataLen)
+	}
+	if dataLen > 0 {
+		l.Data = slices.Clone(b)
+	}
+	return l, nil
+}
+
+// StorageSize returns the number of bytes the logs take in storage, the sum of the
+// lengths of their LogForStorage encodings, without encoding them.
+func (logs Logs) StorageSize() int {
+	var size int
+	for _, l := range logs {
+		topicsLen := len(l.Topics) * (1 + length.Hash)
+		payloadLen := 1 + length.Addr + rlp.ListPrefixLen(topicsLen) + topicsLen + rlp.StringLen(l.Data)
+		size += rlp.ListPrefixLen(payloadLen) + payloadLen
+	}
+	return size
+}
+
+// LogsFromReceiptRLP decodes the logs of a receipt from its consensus encoding, as
+// found in receipt trie leaves: the RLP list of a legacy receipt or the type byte
+// followed by the RLP list of a typed receipt. Only the consensus fields of the
+// logs are set.
+func LogsFromReceiptRLP(receiptRLP []byte) (Logs, error) {
+	if len(receiptRLP) > 0 && receiptRLP[0] <= 0x7f {
+		if len(receiptRLP) <= 1 {
+			return nil, errShortTypedReceipt
+		}
+		receiptRLP = receiptRLP[1:]
+	}
+	s := rlp.NewStream(bytes.NewReader(receiptRLP), uint64(len(receiptRLP)))
+	if _, err := s.List(); err != nil {
+		return nil, fmt.Errorf("receipt: %w", err)
+	}
+	// skip the status, the cumulative gas used and the bloom
+	for _, field := range []string{"status", "cumulative gas used", "bloom"} {
+		if _, err := s.Raw(); err != nil {
+			return nil, fmt.Errorf("receipt %s: %w", field, err)
+		}
+	}
+	var logs Logs
+	if err := s.Decode(&logs); err != nil {
+		return nil, fmt.Errorf("receipt logs: %w", err)
+	}
+	if err := s.ListEnd(); err != nil {
+		return nil, fmt.Errorf("receipt: %w", err)
+	}
+	if _, _, err := s.Kind(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("receipt: %w", rlp.ErrMoreThanOneValue)
+	}
+	return logs, nil
+}
+
+// LogCodec is a storage encoding of logs.
+type LogCodec interface {
+	Encode(*Log) ([]byte, error)
+	Decode([]byte) (*Log, error)
+}
+
+// RLPLogCodec is the LogCodec of the LogForStorage RLP encoding.
+type RLPLogCodec struct{}
+
+var _ LogCodec = RLPLogCodec{}
+
+func (RLPLogCodec) Encode(l *Log) ([]byte, error) {
+	return rlp.EncodeToBytes((*LogForStorage)(l))
+}
+
+func (RLPLogCodec) Decode(b []byte) (*Log, error) {
+	var l LogForStorage
+	if err := rlp.DecodeBytes(b, &l); err != nil {
+		return nil, err
+	}
+	return (*Log)(&l), nil
+}
+
+// CBORLogCodec is the LogCodec of the CBOR encoding of the consensus fields of a log.
+// Its EncodeStoredLogs framing, a CBOR array, is the format of the kv.Log table.
+type CBORLogCodec struct{}
+
+var _ LogCodec = CBORLogCodec{}
+
+func (CBORLogCodec) Encode(l *Log) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cbor.Marshal(&buf, l); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (CBORLogCodec) Decode(b []byte) (*Log, error) {
+	var l Log
+	if err := cbor.Unmarshal(&l, bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (CBORLogCodec) encodeLogs(logs Logs) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cbor.Marshal(&buf, logs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (CBORLogCodec) decodeLogs(b []byte) (Logs, error) {
+	var logs Logs
+	if err := cbor.Unmarshal(&logs, bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// storedLogsCodec is implemented by codecs which frame the logs of a transaction
+// themselves rather than as an RLP list.
+type storedLogsCodec interface {
+	encodeLogs(Logs) ([]byte, error)
+	decodeLogs([]byte) (Logs, error)
+}
+
+// EncodeStoredLogs encodes the logs of a transaction for storage. CBORLogCodec
+// encodes them as a CBOR array, other codecs as an RLP list of the encodings of
+// each log produced by codec.
+func EncodeStoredLogs(codec LogCodec, logs Logs) ([]byte, error) {
+	if c, ok := codec.(storedLogsCodec); ok {
+		return c.encodeLogs(logs)
+	}
+	encs := make([][]byte, len(logs))
+	for i, l := range logs {
+		enc, err := codec.Encode(l)
+		if err != nil {
+			return nil, fmt.Errorf("encode log %d: %w", i, err)
+		}
+		encs[i] = enc
+	}
+	return rlp.EncodeToBytes(encs)
+}
+
+// DecodeStoredLogs decodes logs encoded by EncodeStoredLogs with the same codec.
+func DecodeStoredLogs(codec LogCodec, b []byte) (Logs, error) {
+	if c, ok := codec.(storedLogsCodec); ok {
+		return c.decodeLogs(b)
+	}
+	var encs [][]byte
+	if err := rlp.DecodeBytes(b, &encs); err != nil {
+		return nil, err
+	}
+	logs := make(Logs, len(encs))
+	for i, enc := range encs {
+		l, err := codec.Decode(enc)
+		if err != nil {
+			return nil, fmt.Errorf("decode log %d: %w", i, err)
+		}
+		logs[i] = l
+	}
+	return logs, nil
+}
+
+// EncodeCompressed returns the snappy-compressed storage RLP encoding of the log, as
+// produced by RLPLogCodec.
+func (l *Log) EncodeCompressed() ([]byte, error) {
+	enc, err := RLPLogCodec{}.Encode(l)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, enc), nil
+}
+
+// DecodeCompressedLog decodes a log encoded by Log.EncodeCompressed.
+func DecodeCompressedLog(compressed []byte) (*Log, error) {
+	enc, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy log: %w", err)
+	}
+	return RLPLogCodec{}.Decode(enc)
 }
@@ -0,0 +1,133 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !nofuzz
+
+package types
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	libcommon "github.com/erigontech/erigon-lib/common"
+	"github.com/erigontech/erigon-lib/rlp"
+)
+
+// go test -run '^$' -fuzz=FuzzLogRLP -fuzztime=30s ./core/types
+
+// fuzzLog builds a log from fuzzer input, taking up to maxLogTopics topics from the
+// 32-byte chunks of topics and at most 1KiB of data.
+func fuzzLog(addr []byte, topics []byte, data []byte) *Log {
+	l := &Log{Address: libcommon.BytesToAddress(addr)}
+	for i := 0; i+32 <= len(topics) && len(l.Topics) < maxLogTopics; i += 32 {
+		l.Topics = append(l.Topics, libcommon.BytesToHash(topics[i:i+32]))
+	}
+	if len(data) > 1024 {
+		data = data[:1024]
+	}
+	if len(data) > 0 {
+		l.Data = data
+	}
+	return l
+}
+
+func sameConsensusFields(a, b *Log) bool {
+	return a.Address == b.Address && slices.Equal(a.Topics, b.Topics) && bytes.Equal(a.Data, b.Data)
+}
+
+func FuzzLogRLP(f *testing.F) {
+	f.Add([]byte{}, []byte{}, []byte{}, []byte{})
+	f.Add([]byte{1}, make([]byte, 64), []byte{2, 3}, []byte{0xc0})
+	f.Add(make([]byte, 20), make([]byte, 160), make([]byte, 33), []byte{0xd8, 0x94})
+	f.Fuzz(func(t *testing.T, addr []byte, topics []byte, data []byte, raw []byte) {
+		l := fuzzLog(addr, topics, data)
+		enc, err := rlp.EncodeToBytes(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dec Log
+		if err := rlp.DecodeBytes(enc, &dec); err != nil {
+			t.Fatalf("decoding %x: %v", enc, err)
+		}
+		if !sameConsensusFields(l, &dec) {
+			t.Fatalf("round trip of %x: got %v, want %v", enc, &dec, l)
+		}
+
+		// arbitrary input either fails to decode or is the canonical encoding of the result
+		var arb Log
+		if rlp.DecodeBytes(raw, &arb) != nil {
+			return
+		}
+		if len(arb.Topics) > maxLogTopics {
+			t.Fatalf("decoded %d topics from %x", len(arb.Topics), raw)
+		}
+		reenc, err := rlp.EncodeToBytes(&arb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reenc, raw) {
+			t.Fatalf("%x re-encoded as %x", raw, reenc)
+		}
+	})
+}
+
+func FuzzLogForStorageRLP(f *testing.F) {
+	f.Add([]byte{}, []byte{}, []byte{}, []byte{})
+	f.Add([]byte{1}, make([]byte, 64), []byte{2, 3}, []byte{0xc0})
+	f.Fuzz(func(t *testing.T, addr []byte, topics []byte, data []byte, raw []byte) {
+		l := fuzzLog(addr, topics, data)
+		enc, err := rlp.EncodeToBytes((*LogForStorage)(l))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dec LogForStorage
+		if err := rlp.DecodeBytes(enc, &dec); err != nil {
+			t.Fatalf("decoding %x: %v", enc, err)
+		}
+		if !sameConsensusFields(l, (*Log)(&dec)) {
+			t.Fatalf("round trip of %x: got %v, want %v", enc, (*Log)(&dec), l)
+		}
+
+		legacy, err := rlp.EncodeToBytes(legacyRlpStorageLog{
+			Address: l.Address,
+			Topics:  l.Topics,
+			Data:    l.Data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decLegacy LogForStorage
+		if err := rlp.DecodeBytes(legacy, &decLegacy); err != nil {
+			t.Fatalf("decoding legacy %x: %v", legacy, err)
+		}
+		if !sameConsensusFields(l, (*Log)(&decLegacy)) {
+			t.Fatalf("legacy round trip of %x: got %v, want %v", legacy, (*Log)(&decLegacy), l)
+		}
+		if ok, err := VerifyLogStorageRoundTrip(legacy); !ok || err != nil {
+			t.Fatalf("legacy %x doesn't verify: %v", legacy, err)
+		}
+
+		// whatever decodes must verify
+		var arb LogForStorage
+		if rlp.DecodeBytes(raw, &arb) != nil {
+			return
+		}
+		if ok, err := VerifyLogStorageRoundTrip(raw); !ok || err != nil {
+			t.Fatalf("%x decodes but doesn't verify: %v", raw, err)
+		}
+	})
+}
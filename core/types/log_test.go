@@ -20,15 +20,36 @@
 package types
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"io"
+	"math"
 	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/erigontech/erigon-lib/common/hexutil"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/holiman/uint256"
+	"google.golang.org/protobuf/proto"
+
 	libcommon "github.com/erigontech/erigon-lib/common"
+	"github.com/erigontech/erigon-lib/crypto"
+	remote "github.com/erigontech/erigon-lib/gointerfaces/remoteproto"
+	"github.com/erigontech/erigon-lib/rlp"
+
+	"github.com/erigontech/erigon/ethdb/cbor"
 )
 
 var unmarshalLogTests = map[string]struct {
@@ -233,11 +254,16 @@ func TestFilterLogsTopics(t *testing.T) {
 			want:   []libcommon.Address{a1},
 		},
 	}
+	var ws FilterWorkspace
 	for name, v := range filterLogTests {
 		ares := testFLExtractAddress(v.input.Filter(map[libcommon.Address]struct{}{}, v.filter, 0))
 		if !reflect.DeepEqual(ares, v.want) {
 			t.Errorf("Fail %s, got %v want %v", name, ares, v.want)
 		}
+		ws_res := testFLExtractAddress(ws.Filter(v.input, map[libcommon.Address]struct{}{}, v.filter, 0))
+		if !reflect.DeepEqual(ws_res, v.want) {
+			t.Errorf("Fail Workspace %s, got %v want %v", name, ws_res, v.want)
+		}
 		old_res := testFLExtractAddress(v.input.FilterOld(map[libcommon.Address]struct{}{}, v.filter))
 		if !reflect.DeepEqual(old_res, v.want) {
 			t.Errorf("Fail Old %s, got %v want %v", name, old_res, v.want)
@@ -251,3 +277,3355 @@ func testFLExtractAddress(xs Logs) (o []libcommon.Address) {
 	}
 	return
 }
+
+func testFLExtractIndex(xs Logs) (o []uint) {
+	for _, v := range xs {
+		o = append(o, v.Index)
+	}
+	return
+}
+
+func TestRecentLogs(t *testing.T) {
+	t.Parallel()
+	mk := func(i uint) *Log { return &Log{Index: i} }
+
+	r := NewRecentLogs(3)
+	if got := r.Snapshot(); len(got) != 0 {
+		t.Fatalf("expected empty snapshot, got %v", testFLExtractIndex(got))
+	}
+	r.Add(mk(0))
+	r.Add(mk(1))
+	if got := testFLExtractIndex(r.Snapshot()); !reflect.DeepEqual(got, []uint{0, 1}) {
+		t.Fatalf("partial buffer: got %v", got)
+	}
+	r.Add(mk(2))
+	r.Add(mk(3))
+	if got := testFLExtractIndex(r.Snapshot()); !reflect.DeepEqual(got, []uint{1, 2, 3}) {
+		t.Fatalf("after first eviction: got %v", got)
+	}
+	for i := uint(4); i < 11; i++ {
+		r.Add(mk(i))
+	}
+	if got := testFLExtractIndex(r.Snapshot()); !reflect.DeepEqual(got, []uint{8, 9, 10}) {
+		t.Fatalf("after wraparound: got %v", got)
+	}
+}
+
+func TestFilterIgnoringPositions(t *testing.T) {
+	t.Parallel()
+	var (
+		transfer libcommon.Hash = [32]byte{1}
+		implA    libcommon.Hash = [32]byte{2}
+		implB    libcommon.Hash = [32]byte{3}
+		to       libcommon.Hash = [32]byte{4}
+
+		a1 libcommon.Address = [20]byte{1}
+		a2 libcommon.Address = [20]byte{2}
+		a3 libcommon.Address = [20]byte{3}
+	)
+	logs := Logs{
+		{Address: a1, Topics: []libcommon.Hash{transfer, implA, to}},
+		{Address: a2, Topics: []libcommon.Hash{transfer, implB, to}},
+		{Address: a3, Topics: []libcommon.Hash{transfer}},
+	}
+	filter := [][]libcommon.Hash{{transfer}, {implA}, {to}}
+
+	if got := testFLExtractAddress(logs.Filter(nil, filter, 0)); !reflect.DeepEqual(got, []libcommon.Address{a1}) {
+		t.Fatalf("plain filter: got %v", got)
+	}
+	got := testFLExtractAddress(logs.FilterIgnoringPositions(nil, filter, []int{1}, 0))
+	if !reflect.DeepEqual(got, []libcommon.Address{a1, a2}) {
+		t.Fatalf("ignoring position 1: got %v", got)
+	}
+	// out of range and duplicate positions are harmless
+	got = testFLExtractAddress(logs.FilterIgnoringPositions(nil, filter, []int{1, 1, 7, -1}, 0))
+	if !reflect.DeepEqual(got, []libcommon.Address{a1, a2}) {
+		t.Fatalf("ignoring odd positions: got %v", got)
+	}
+	// a mismatching topic at an ignored position doesn't exclude the log
+	got = testFLExtractAddress(logs.FilterIgnoringPositions(nil, [][]libcommon.Hash{{to}, {implA}}, []int{0}, 0))
+	if !reflect.DeepEqual(got, []libcommon.Address{a1}) {
+		t.Fatalf("ignoring position 0: got %v", got)
+	}
+	if filter[1][0] != implA {
+		t.Fatalf("topics argument was modified")
+	}
+}
+
+func TestLogCanonicalID(t *testing.T) {
+	t.Parallel()
+	l := &Log{
+		BlockHash: libcommon.HexToHash("0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056"),
+		TxIndex:   3,
+		Index:     0x1f,
+	}
+	id := l.CanonicalID()
+	if want := "0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056-0x3-0x1f"; id != want {
+		t.Fatalf("got %s, want %s", id, want)
+	}
+	blockHash, txIndex, logIndex, err := ParseCanonicalID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockHash != l.BlockHash || txIndex != l.TxIndex || logIndex != l.Index {
+		t.Fatalf("round trip mismatch: %x %d %d", blockHash, txIndex, logIndex)
+	}
+	if id := (&Log{}).CanonicalID(); id != (libcommon.Hash{}).Hex()+"-0x0-0x0" {
+		t.Fatalf("zero log: got %s", id)
+	}
+
+	for _, bad := range []string{
+		"",
+		"0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056-0x3",
+		"0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056-0x3-0x1f-0x1",
+		"0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad6810-0x3-0x1f",
+		"656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056-0x3-0x1f",
+		"0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056-3-0x1f",
+		"0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056-0x3-0x01f",
+		"0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056-0x3-0xzz",
+	} {
+		if _, _, _, err := ParseCanonicalID(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCompiledFilterApplyBlocks(t *testing.T) {
+	t.Parallel()
+	var (
+		A libcommon.Hash = [32]byte{1}
+		B libcommon.Hash = [32]byte{2}
+		C libcommon.Hash = [32]byte{3}
+
+		a1 libcommon.Address = [20]byte{1}
+		a2 libcommon.Address = [20]byte{2}
+	)
+	block := func(logs ...*Log) BlockLogs {
+		return BlockLogs{Bloom: BytesToBloom(LogsBloom(logs)), Logs: logs}
+	}
+	blocks := []BlockLogs{
+		block(&Log{Address: a1, Topics: []libcommon.Hash{A, B}, Index: 0}, &Log{Address: a2, Topics: []libcommon.Hash{A}, Index: 1}),
+		block(&Log{Address: a2, Topics: []libcommon.Hash{C}, Index: 2}),
+		block(&Log{Address: a1, Topics: []libcommon.Hash{A, C}, Index: 3}, &Log{Address: a1, Topics: []libcommon.Hash{A, B}, Index: 4}),
+		// bloom left empty: the block must be skipped even though its logs match
+		{Logs: Logs{{Address: a1, Topics: []libcommon.Hash{A, B}, Index: 5}}},
+	}
+	addrs := map[libcommon.Address]struct{}{a1: {}}
+	cf := CompileFilter(addrs, [][]libcommon.Hash{{A}, {B}})
+
+	if cf.BloomMatches(&blocks[1].Bloom) {
+		t.Fatalf("block 1 bloom should not match")
+	}
+	got := cf.ApplyBlocks(blocks, 0)
+	if len(got) != 2 || got[0].Index != 0 || got[1].Index != 4 {
+		t.Fatalf("unexpected matches %v", got)
+	}
+	got = cf.ApplyBlocks(blocks, 1)
+	if len(got) != 1 || got[0].Index != 0 {
+		t.Fatalf("maxLogs not respected across blocks: %v", got)
+	}
+
+	// same results as Logs.Filter on the flattened input
+	var all Logs
+	for _, b := range blocks[:3] {
+		all = append(all, b.Logs...)
+	}
+	for _, topics := range [][][]libcommon.Hash{nil, {{A}}, {{}, {C}}, {{A, C}, {B, C}}} {
+		want := all.Filter(addrs, topics, 0)
+		have := CompileFilter(addrs, topics).ApplyBlocks(blocks[:3], 0)
+		if len(want) != len(have) {
+			t.Fatalf("topics %v: got %d logs, want %d", topics, len(have), len(want))
+		}
+		for i := range want {
+			if want[i] != have[i] {
+				t.Fatalf("topics %v: mismatch at %d", topics, i)
+			}
+		}
+	}
+}
+
+func BenchmarkCompiledFilterApplyBlocks(b *testing.B) {
+	const numBlocks = 10_000
+	var (
+		wanted libcommon.Address = [20]byte{0xff}
+		sig    libcommon.Hash    = [32]byte{0xff}
+	)
+	blocks := make([]BlockLogs, numBlocks)
+	for i := range blocks {
+		logs := make(Logs, 10)
+		for j := range logs {
+			var addr libcommon.Address
+			var topic libcommon.Hash
+			binary.BigEndian.PutUint32(addr[:], uint32(i*10+j))
+			binary.BigEndian.PutUint32(topic[:], uint32(j))
+			logs[j] = &Log{Address: addr, Topics: []libcommon.Hash{topic}}
+		}
+		// roughly one block in a thousand contains a matching log
+		if i%1000 == 0 {
+			logs[0] = &Log{Address: wanted, Topics: []libcommon.Hash{sig}}
+		}
+		blocks[i] = BlockLogs{Bloom: BytesToBloom(LogsBloom(logs)), Logs: logs}
+	}
+	cf := CompileFilter(map[libcommon.Address]struct{}{wanted: {}}, [][]libcommon.Hash{{sig}})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if res := cf.ApplyBlocks(blocks, 0); len(res) != numBlocks/1000 {
+			b.Fatalf("got %d matches", len(res))
+		}
+	}
+}
+
+func TestFilterKeepsRemovedLogs(t *testing.T) {
+	t.Parallel()
+	var (
+		A  libcommon.Hash    = [32]byte{1}
+		a1 libcommon.Address = [20]byte{1}
+	)
+	logs := Logs{
+		{Address: a1, Topics: []libcommon.Hash{A}, Index: 0},
+		{Address: a1, Topics: []libcommon.Hash{A}, Index: 1, Removed: true},
+	}
+	addrs := map[libcommon.Address]struct{}{a1: {}}
+	for name, res := range map[string]Logs{
+		"Filter":         logs.Filter(addrs, [][]libcommon.Hash{{A}}, 0),
+		"CointainTopics": logs.CointainTopics(addrs, map[libcommon.Hash]struct{}{A: {}}, 0),
+		"FilterOld":      logs.FilterOld(addrs, [][]libcommon.Hash{{A}}),
+	} {
+		if len(res) != 2 || !res[1].Removed {
+			t.Errorf("%s dropped the removed log: %v", name, res)
+		}
+	}
+}
+
+func TestLogEncodeEventLogArgs(t *testing.T) {
+	t.Parallel()
+	orig := &Log{
+		Address: libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7"),
+		Topics: []libcommon.Hash{
+			libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"),
+			libcommon.HexToHash("0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"),
+			libcommon.HexToHash("0x000000000000000000000000a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"),
+		},
+		Data: hexutil.MustDecode("0x000000000000000000000000000000000000000000000001a055690d9db80000"),
+	}
+	topics, data := orig.EncodeEventLogArgs()
+
+	// Emulate LOGn: data is placed in memory and the topics are pushed onto the stack
+	// as words, then the interpreter rebuilds the log from its operands.
+	const mStart = 7
+	memory := make([]byte, mStart+len(data)+5)
+	copy(memory[mStart:], data)
+	stack := make([]uint256.Int, len(topics))
+	for i := range topics {
+		stack[i].SetBytes32(topics[i][:])
+	}
+	emitted := &Log{Address: orig.Address, Topics: make([]libcommon.Hash, len(stack))}
+	for i := range stack {
+		emitted.Topics[i] = stack[i].Bytes32()
+	}
+	emitted.Data = append([]byte{}, memory[mStart:mStart+len(data)]...)
+
+	if !reflect.DeepEqual(emitted, orig) {
+		t.Fatalf("emitted log differs:\ngot  %v\nwant %v", emitted, orig)
+	}
+	data[0] = 0xff
+	if orig.Data[0] == 0xff {
+		t.Fatalf("returned data aliases the log data")
+	}
+
+	topics, data = (&Log{}).EncodeEventLogArgs()
+	if len(topics) != 0 || len(data) != 0 {
+		t.Fatalf("LOG0 with empty data: got %d topics and %d bytes", len(topics), len(data))
+	}
+}
+
+func TestFilterByDataLen(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Index: 0},
+		{Index: 1, Data: []byte{}},
+		{Index: 2, Data: make([]byte, 32)},
+		{Index: 3, Data: make([]byte, 33)},
+		{Index: 4, Data: make([]byte, 64)},
+	}
+	tests := []struct {
+		minLen, maxLen int
+		maxLogs        uint64
+		want           []uint
+	}{
+		{0, 0, 0, []uint{0, 1}},
+		{0, -1, 0, []uint{0, 1, 2, 3, 4}},
+		{32, 32, 0, []uint{2}},
+		{32, 64, 0, []uint{2, 3, 4}},
+		{33, -1, 0, []uint{3, 4}},
+		{65, -1, 0, nil},
+		{0, -1, 2, []uint{0, 1}},
+	}
+	for _, tt := range tests {
+		got := testFLExtractIndex(logs.FilterByDataLen(tt.minLen, tt.maxLen, tt.maxLogs))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("[%d, %d] max %d: got %v, want %v", tt.minLen, tt.maxLen, tt.maxLogs, got, tt.want)
+		}
+	}
+}
+
+func benchmarkFilterInput() (Logs, [][]libcommon.Hash) {
+	logs := make(Logs, 100)
+	for i := range logs {
+		var topic libcommon.Hash
+		binary.BigEndian.PutUint32(topic[:], uint32(i%10))
+		logs[i] = &Log{Topics: []libcommon.Hash{topic, topic}}
+	}
+	return logs, [][]libcommon.Hash{{{0}, {1}}, {}}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	logs, topics := benchmarkFilterInput()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logs.Filter(nil, topics, 0)
+	}
+}
+
+func BenchmarkFilterWorkspace(b *testing.B) {
+	logs, topics := benchmarkFilterInput()
+	var ws FilterWorkspace
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ws.Filter(logs, nil, topics, 0)
+	}
+}
+
+func TestLogsCanonicalOrder(t *testing.T) {
+	t.Parallel()
+	mk := func(block uint64, tx, idx uint) *Log { return &Log{BlockNumber: block, TxIndex: tx, Index: idx} }
+	sorted := Logs{mk(1, 0, 0), mk(1, 0, 1), mk(1, 2, 2), mk(2, 0, 0), mk(2, 0, 0), mk(3, 1, 4)}
+
+	tests := map[string]struct {
+		input     Logs
+		canonical bool
+	}{
+		"empty":            {Logs{}, true},
+		"single":           {Logs{mk(5, 5, 5)}, true},
+		"sorted":           {slices.Clone(sorted), true},
+		"reversed":         {Logs{sorted[5], sorted[4], sorted[3], sorted[2], sorted[1], sorted[0]}, false},
+		"partially sorted": {Logs{sorted[0], sorted[1], sorted[3], sorted[2], sorted[4], sorted[5]}, false},
+		"index only":       {Logs{mk(1, 0, 1), mk(1, 0, 0)}, false},
+	}
+	for name, tt := range tests {
+		if got := tt.input.IsCanonicalOrder(); got != tt.canonical {
+			t.Errorf("%s: IsCanonicalOrder got %v, want %v", name, got, tt.canonical)
+		}
+		tt.input.Sort()
+		if !tt.input.IsCanonicalOrder() {
+			t.Errorf("%s: not canonical after Sort", name)
+		}
+	}
+
+	input := Logs{sorted[5], sorted[2], sorted[0], sorted[4], sorted[3], sorted[1]}
+	input.Sort()
+	for i := range input {
+		if compareLogsCanonical(input[i], sorted[i]) != 0 {
+			t.Fatalf("position %d: got %+v, want %+v", i, input[i], sorted[i])
+		}
+	}
+}
+
+func TestLogsPage(t *testing.T) {
+	t.Parallel()
+	logs := make(Logs, 5)
+	for i := range logs {
+		logs[i] = &Log{Index: uint(i)}
+	}
+	tests := []struct {
+		offset, limit int
+		want          []uint
+		hasMore       bool
+	}{
+		{0, 2, []uint{0, 1}, true},
+		{2, 2, []uint{2, 3}, true},
+		{4, 2, []uint{4}, false},
+		{3, 2, []uint{3, 4}, false},
+		{0, 5, []uint{0, 1, 2, 3, 4}, false},
+		{0, 100, []uint{0, 1, 2, 3, 4}, false},
+		{5, 2, nil, false},
+		{100, 2, nil, false},
+		{-1, 1, []uint{0}, true},
+		{1, 0, nil, true},
+	}
+	for _, tt := range tests {
+		page, hasMore := logs.Page(tt.offset, tt.limit)
+		if got := testFLExtractIndex(page); !reflect.DeepEqual(got, tt.want) || hasMore != tt.hasMore {
+			t.Errorf("Page(%d, %d): got %v %v, want %v %v", tt.offset, tt.limit, got, hasMore, tt.want, tt.hasMore)
+		}
+	}
+	if page, hasMore := Logs(nil).Page(0, 10); len(page) != 0 || hasMore {
+		t.Errorf("nil logs: got %v %v", page, hasMore)
+	}
+}
+
+func TestLogProtoRoundTrip(t *testing.T) {
+	t.Parallel()
+	l := &Log{
+		Address: libcommon.HexToAddress("0xecf8f87f810ecf450940c9f60066b4a7a501d6a7"),
+		Topics: []libcommon.Hash{
+			libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"),
+			libcommon.HexToHash("0x00000000000000000000000080b2c9d7cbbf30a1b0fc8983c647d754c6525615"),
+		},
+		Data:        hexutil.MustDecode("0x000000000000000000000000000000000000000000000001a055690d9db80000"),
+		BlockNumber: 2019236,
+		TxHash:      libcommon.HexToHash("0x3b198bfd5d2907285af009e9ae84a0ecd63677110d89d7e030251acb87f6487e"),
+		TxIndex:     3,
+		BlockHash:   libcommon.HexToHash("0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056"),
+		Index:       2,
+		Removed:     true,
+	}
+	// make sure every field but TxSuccess takes part in the round trip
+	v := reflect.ValueOf(l).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Name; name != "TxSuccess" && v.Field(i).IsZero() {
+			t.Fatalf("field %s is not populated", name)
+		}
+	}
+
+	enc, err := proto.Marshal(l.ToProto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p remote.SubscribeLogsReply
+	if err := proto.Unmarshal(enc, &p); err != nil {
+		t.Fatal(err)
+	}
+	if got := LogFromProto(&p); !reflect.DeepEqual(got, l) {
+		t.Fatalf("round trip mismatch:\ngot  %+v\nwant %+v", got, l)
+	}
+	if LogFromProto(nil) != nil {
+		t.Fatal("expected nil log for nil message")
+	}
+}
+
+func TestLogsReverse(t *testing.T) {
+	t.Parallel()
+	mk := func(n int) Logs {
+		logs := make(Logs, n)
+		for i := range logs {
+			logs[i] = &Log{Index: uint(i)}
+		}
+		return logs
+	}
+	tests := map[string]struct {
+		input Logs
+		want  []uint
+	}{
+		"empty": {Logs{}, nil},
+		"one":   {mk(1), []uint{0}},
+		"even":  {mk(4), []uint{3, 2, 1, 0}},
+		"odd":   {mk(5), []uint{4, 3, 2, 1, 0}},
+	}
+	for name, tt := range tests {
+		orig := testFLExtractIndex(tt.input)
+		reversed := tt.input.Reversed()
+		if got := testFLExtractIndex(reversed); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Reversed got %v, want %v", name, got, tt.want)
+		}
+		if got := testFLExtractIndex(tt.input); !reflect.DeepEqual(got, orig) {
+			t.Errorf("%s: Reversed modified the input: %v", name, got)
+		}
+		tt.input.Reverse()
+		if got := testFLExtractIndex(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Reverse got %v, want %v", name, got, tt.want)
+		}
+	}
+	if Logs(nil).Reversed() != nil {
+		t.Errorf("expected nil for nil logs")
+	}
+}
+
+func TestFilterSuccessfulOnly(t *testing.T) {
+	t.Parallel()
+	success, failure := true, false
+	logs := Logs{
+		{Index: 0},
+		{Index: 1, TxSuccess: &success},
+		{Index: 2, TxSuccess: &failure},
+		{Index: 3},
+	}
+	if got := testFLExtractIndex(logs.FilterSuccessfulOnly()); !reflect.DeepEqual(got, []uint{0, 1, 3}) {
+		t.Fatalf("got %v", got)
+	}
+
+	// TxSuccess is not part of the consensus encoding
+	for _, l := range logs {
+		plain := &Log{Address: libcommon.Address{1}, Topics: []libcommon.Hash{{2}}, Data: []byte{3}}
+		tagged := plain.Copy()
+		tagged.TxSuccess = l.TxSuccess
+		want, err := rlp.EncodeToBytes(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rlp.EncodeToBytes(tagged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("log %d: rlp encoding changed by TxSuccess", l.Index)
+		}
+		got, err = rlp.EncodeToBytes((*LogForStorage)(tagged))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("log %d: storage encoding changed by TxSuccess", l.Index)
+		}
+	}
+
+	cpy := logs[2].Copy()
+	*cpy.TxSuccess = true
+	if logs[2].Succeeded() {
+		t.Fatalf("Copy shares TxSuccess with the original")
+	}
+}
+
+func TestLogTopicsChecksum(t *testing.T) {
+	t.Parallel()
+	l := &Log{
+		Topics: []libcommon.Hash{
+			libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"),
+			libcommon.HexToHash("0x00000000000000000000000080b2c9d7cbbf30a1b0fc8983c647d754c6525615"),
+		},
+	}
+	sum := l.TopicsChecksum()
+	if sum != l.Copy().TopicsChecksum() {
+		t.Fatalf("checksum is not deterministic")
+	}
+	for i := range l.Topics {
+		for j := 0; j < len(l.Topics[i]); j++ {
+			corrupted := l.Copy()
+			corrupted.Topics[i][j] ^= 1
+			if corrupted.TopicsChecksum() == sum {
+				t.Fatalf("flipping a bit of topic %d byte %d didn't change the checksum", i, j)
+			}
+		}
+	}
+	if (&Log{Topics: l.Topics[:1]}).TopicsChecksum() == sum {
+		t.Fatalf("dropping a topic didn't change the checksum")
+	}
+}
+
+func TestLogsWriteNDJSON(t *testing.T) {
+	t.Parallel()
+	var logs Logs
+	for _, test := range unmarshalLogTests {
+		if test.want != nil {
+			logs = append(logs, test.want)
+		}
+	}
+	var buf bytes.Buffer
+	if err := logs.WriteNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(logs) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(logs))
+	}
+	for i, line := range lines {
+		var l *Log
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(l, logs[i]) {
+			t.Fatalf("line %d: got %v, want %v", i, l, logs[i])
+		}
+	}
+
+	buf.Reset()
+	if err := (Logs{}).WriteNDJSON(&buf); err != nil || buf.Len() != 0 {
+		t.Fatalf("empty logs: got %q, %v", buf.String(), err)
+	}
+}
+
+func TestFilterTopicAt(t *testing.T) {
+	t.Parallel()
+	var (
+		A libcommon.Hash = [32]byte{1}
+		B libcommon.Hash = [32]byte{2}
+	)
+	logs := Logs{
+		{Index: 0, Topics: []libcommon.Hash{A, B}},
+		{Index: 1, Topics: []libcommon.Hash{B, A}},
+		{Index: 2, Topics: []libcommon.Hash{A}},
+		{Index: 3},
+		{Index: 4, Topics: []libcommon.Hash{B, A, A}},
+	}
+	tests := []struct {
+		position int
+		topic    libcommon.Hash
+		maxLogs  uint64
+		want     []uint
+	}{
+		{0, A, 0, []uint{0, 2}},
+		{1, A, 0, []uint{1, 4}},
+		{1, A, 1, []uint{1}},
+		{2, A, 0, []uint{4}},
+		{3, A, 0, nil},
+		{-1, A, 0, nil},
+	}
+	for _, tt := range tests {
+		got := testFLExtractIndex(logs.FilterTopicAt(tt.position, tt.topic, tt.maxLogs))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("position %d: got %v, want %v", tt.position, got, tt.want)
+		}
+		// same result as the general positional filter
+		if tt.position < 0 || tt.maxLogs != 0 {
+			continue
+		}
+		topics := make([][]libcommon.Hash, tt.position+1)
+		topics[tt.position] = []libcommon.Hash{tt.topic}
+		if want := testFLExtractIndex(logs.Filter(nil, topics, 0)); !reflect.DeepEqual(got, want) {
+			t.Errorf("position %d: got %v, Filter returned %v", tt.position, got, want)
+		}
+	}
+	// like Filter, no match is an empty slice rather than nil
+	for _, position := range []int{3, -1} {
+		if got := logs.FilterTopicAt(position, A, 0); got == nil || len(got) != 0 {
+			t.Errorf("position %d: got %#v, want empty Logs", position, got)
+		}
+	}
+}
+
+func BenchmarkFilterTopicAt(b *testing.B) {
+	logs, _ := benchmarkFilterInput()
+	topic := libcommon.Hash{}
+	b.Run("FilterTopicAt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logs.FilterTopicAt(1, topic, 0)
+		}
+	})
+	b.Run("Filter", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logs.Filter(nil, [][]libcommon.Hash{{}, {topic}}, 0)
+		}
+	})
+}
+
+func TestLazyLog(t *testing.T) {
+	t.Parallel()
+	full := &Log{
+		Address:     libcommon.Address{1},
+		Topics:      []libcommon.Hash{{2}, {3}},
+		Data:        []byte{4},
+		BlockNumber: 5,
+		TxIndex:     6,
+		Index:       7,
+	}
+	summary := full.Summary()
+	if summary.Address != full.Address || summary.Signature != full.Topics[0] ||
+		summary.BlockNumber != 5 || summary.TxIndex != 6 || summary.Index != 7 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+
+	var calls atomic.Int32
+	lazy := NewLazyLog(summary, func() (*Log, error) {
+		calls.Add(1)
+		return full, nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l, err := lazy.Resolve(); err != nil || l != full {
+				t.Errorf("Resolve returned %v, %v", l, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("fetch called %d times", n)
+	}
+
+	calls.Store(0)
+	fetchErr := errors.New("cold storage unavailable")
+	failing := NewLazyLog(LogSummary{}, func() (*Log, error) {
+		calls.Add(1)
+		return nil, fetchErr
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := failing.Resolve(); !errors.Is(err, fetchErr) {
+			t.Fatalf("got error %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("failing fetch called %d times", n)
+	}
+}
+
+func TestLogsBloomWith(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Address: libcommon.Address{1}, Topics: []libcommon.Hash{{2}, {3}}},
+		{Address: libcommon.Address{4}},
+	}
+	if got, want := logs.BloomWith(nil), BytesToBloom(LogsBloom(logs)); got != want {
+		t.Fatalf("nil hasher must default to keccak256")
+	}
+	if logs.Bloom() != logs.BloomWith(nil) {
+		t.Fatalf("Bloom differs from BloomWith(nil)")
+	}
+
+	// The stub hasher maps every item to a hash selecting bits 0, 0x102 and 0x7ff,
+	// which live in bytes 255, 223 and 0 of the bloom.
+	var calls int
+	stub := func(d []byte) libcommon.Hash {
+		calls++
+		return libcommon.Hash{0x00, 0x00, 0x01, 0x02, 0x07, 0xff}
+	}
+	bloom := logs.BloomWith(stub)
+	if calls != 4 {
+		t.Fatalf("hasher called %d times, want 4", calls)
+	}
+	var want Bloom
+	want[255] = 1 << 0
+	want[223] = 1 << 2
+	want[0] = 1 << 7
+	if bloom != want {
+		t.Fatalf("got bloom %x, want %x", bloom[:], want[:])
+	}
+	if got := logs.BloomBits(stub); !reflect.DeepEqual(got, []uint{0, 0x102, 0x7ff}) {
+		t.Fatalf("got bloom bits %v, want [0 258 2047]", got)
+	}
+	if !logs.BloomMatches(want, stub) {
+		t.Fatalf("logs must match the bloom built with the same hasher")
+	}
+	if logs.BloomMatches(want, nil) {
+		t.Fatalf("logs must not match the stub bloom under keccak256")
+	}
+
+	// with keccak256 the helpers agree with the consensus bloom
+	keccakBloom := BytesToBloom(LogsBloom(logs))
+	if !logs.BloomMatches(keccakBloom, nil) {
+		t.Fatalf("logs must match their own bloom")
+	}
+	var fromBits Bloom
+	for _, bit := range logs.BloomBits(nil) {
+		fromBits[BloomByteLength-1-bit/8] |= 1 << (bit % 8)
+	}
+	if fromBits != keccakBloom {
+		t.Fatalf("bloom bits %v do not rebuild the bloom", logs.BloomBits(nil))
+	}
+	other := Logs{{Address: libcommon.Address{5}}}
+	if got, want := other.BloomMatches(keccakBloom, nil), keccakBloom.Test(other[0].Address[:]); got != want {
+		t.Fatalf("BloomMatches returned %v, Bloom.Test %v", got, want)
+	}
+}
+
+func TestLogsCoalesceRuns(t *testing.T) {
+	t.Parallel()
+	var (
+		transfer libcommon.Hash    = [32]byte{1}
+		approval libcommon.Hash    = [32]byte{2}
+		a1       libcommon.Address = [20]byte{1}
+		a2       libcommon.Address = [20]byte{2}
+	)
+	logs := Logs{
+		{Index: 0, Address: a1, Topics: []libcommon.Hash{transfer, {9}}},
+		{Index: 1, Address: a1, Topics: []libcommon.Hash{transfer, {8}}},
+		{Index: 2, Address: a1, Topics: []libcommon.Hash{transfer}},
+		{Index: 3, Address: a2, Topics: []libcommon.Hash{transfer}},
+		{Index: 4, Address: a1, Topics: []libcommon.Hash{transfer}},
+		{Index: 5, Address: a1, Topics: []libcommon.Hash{approval}},
+		{Index: 6, Address: a1},
+		{Index: 7, Address: a1},
+		{Index: 8, Address: a1, Topics: []libcommon.Hash{{}}},
+	}
+	type run struct {
+		index uint
+		count int
+	}
+	var got []run
+	for _, r := range logs.CoalesceRuns() {
+		got = append(got, run{r.Log.Index, r.Count})
+	}
+	want := []run{{0, 3}, {3, 1}, {4, 1}, {5, 1}, {6, 2}, {8, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if runs := (Logs{}).CoalesceRuns(); len(runs) != 0 {
+		t.Fatalf("expected no runs, got %v", runs)
+	}
+}
+
+func TestVerifyLogStorageRoundTrip(t *testing.T) {
+	t.Parallel()
+	l := &Log{
+		Address:     libcommon.HexToAddress("0xecf8f87f810ecf450940c9f60066b4a7a501d6a7"),
+		Topics:      []libcommon.Hash{libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")},
+		Data:        []byte{1, 2, 3},
+		BlockNumber: 2019236,
+		TxHash:      libcommon.HexToHash("0x3b198bfd5d2907285af009e9ae84a0ecd63677110d89d7e030251acb87f6487e"),
+		TxIndex:     3,
+		BlockHash:   libcommon.HexToHash("0x656c34545f90a730a19008c0e7a7cd4fb3895064b48d6d69761bd5abad681056"),
+		Index:       2,
+	}
+
+	current, err := rlp.EncodeToBytes((*LogForStorage)(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyLogStorageRoundTrip(current); err != nil || !ok {
+		t.Fatalf("current format: got %v, %v", ok, err)
+	}
+
+	// the legacy format encodes to the same bytes as the current one
+	legacy, err := rlp.EncodeToBytes(legacyRlpStorageLog{Address: l.Address, Topics: l.Topics, Data: l.Data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(legacy, current) {
+		t.Fatalf("legacy format: got %x, want %x", legacy, current)
+	}
+	if ok, err := VerifyLogStorageRoundTrip(legacy); err != nil || !ok {
+		t.Fatalf("legacy format: got %v, %v", ok, err)
+	}
+
+	// a blob with trailing derived fields decodes as neither format
+	withDerived, err := rlp.EncodeToBytes([]interface{}{l.Address, l.Topics, l.Data, l.BlockNumber, l.TxHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyLogStorageRoundTrip(withDerived); err == nil {
+		t.Fatalf("expected error for blob with derived fields")
+	}
+
+	// blobs which are not a stored log are reported as errors rather than mismatches
+	if _, err := VerifyLogStorageRoundTrip([]byte{0xc1, 0x80}); err == nil {
+		t.Fatalf("expected error for malformed blob")
+	}
+}
+
+func TestLogDecodeErrors(t *testing.T) {
+	t.Parallel()
+	topic := libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	valid, err := rlp.EncodeToBytes(&Log{Address: libcommon.Address{1}, Topics: []libcommon.Hash{topic}, Data: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tooMany, err := rlp.EncodeToBytes(&Log{Topics: make([]libcommon.Hash, 5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortTopic, err := rlp.EncodeToBytes(struct {
+		Address libcommon.Address
+		Topics  [][]byte
+		Data    []byte
+	}{Topics: [][]byte{topic[:], topic[:31]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listTopic, err := rlp.EncodeToBytes(struct {
+		Address libcommon.Address
+		Topics  [][][]byte
+		Data    []byte
+	}{Topics: [][][]byte{{topic[:]}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodeRaw := func(addr []byte, topics [][]byte) []byte {
+		enc, err := rlp.EncodeToBytes(struct {
+			Address []byte
+			Topics  [][]byte
+			Data    []byte
+		}{addr, topics, nil})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+	listAddress, err := rlp.EncodeToBytes(struct {
+		Address [][]byte
+		Topics  [][]byte
+		Data    []byte
+	}{Address: [][]byte{make([]byte, 20)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		input []byte
+		want  error
+	}{
+		"short address":   {encodeRaw(make([]byte, 19), nil), ErrMalformedAddress},
+		"long address":    {encodeRaw(make([]byte, 21), nil), ErrMalformedAddress},
+		"empty address":   {encodeRaw(nil, nil), ErrMalformedAddress},
+		"list address":    {listAddress, ErrMalformedAddress},
+		"long topic":      {encodeRaw(make([]byte, 20), [][]byte{make([]byte, 33)}), ErrMalformedTopic},
+		"empty topic":     {encodeRaw(make([]byte, 20), [][]byte{{}}), ErrMalformedTopic},
+		"empty":           {[]byte{}, ErrLogTruncated},
+		"truncated list":  {valid[:len(valid)-1], ErrLogTruncated},
+		"truncated topic": {valid[:30], ErrLogTruncated},
+		"five topics":     {tooMany, ErrTooManyTopics},
+		"short topic":     {shortTopic, ErrMalformedTopic},
+		"list topic":      {listTopic, ErrMalformedTopic},
+	}
+	for name, tt := range tests {
+		var l Log
+		if err := rlp.DecodeBytes(tt.input, &l); !errors.Is(err, tt.want) {
+			t.Errorf("Log %s: got %v, want %v", name, err, tt.want)
+		}
+		var ls LogForStorage
+		if err := rlp.DecodeBytes(tt.input, &ls); !errors.Is(err, tt.want) {
+			t.Errorf("LogForStorage %s: got %v, want %v", name, err, tt.want)
+		}
+	}
+
+	var l Log
+	if err := rlp.DecodeBytes(valid, &l); err != nil {
+		t.Fatalf("valid log: %v", err)
+	}
+	four, err := rlp.EncodeToBytes(&Log{Topics: make([]libcommon.Hash, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rlp.DecodeBytes(four, &l); err != nil || len(l.Topics) != 4 {
+		t.Fatalf("four topics: %v", err)
+	}
+}
+
+func TestFilterByTxHashes(t *testing.T) {
+	t.Parallel()
+	var (
+		tx1 libcommon.Hash = [32]byte{1}
+		tx2 libcommon.Hash = [32]byte{2}
+		tx3 libcommon.Hash = [32]byte{3}
+	)
+	logs := Logs{
+		{Index: 0, TxHash: tx1},
+		{Index: 1, TxHash: tx1},
+		{Index: 2, TxHash: tx2},
+		{Index: 3, TxHash: tx3},
+		{Index: 4, TxHash: tx3},
+	}
+	got := testFLExtractIndex(logs.FilterByTxHashes(map[libcommon.Hash]struct{}{tx1: {}, tx3: {}}))
+	if want := []uint{0, 1, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := logs.FilterByTxHashes(map[libcommon.Hash]struct{}{{9}: {}}); len(got) != 0 {
+		t.Fatalf("unknown transaction: got %v", got)
+	}
+	if got := logs.FilterByTxHashes(nil); len(got) != 0 {
+		t.Fatalf("no transactions: got %v", got)
+	}
+}
+
+func TestFilterAtLeastNTopics(t *testing.T) {
+	t.Parallel()
+	var (
+		A libcommon.Hash = [32]byte{1}
+		B libcommon.Hash = [32]byte{2}
+		C libcommon.Hash = [32]byte{3}
+		D libcommon.Hash = [32]byte{4}
+
+		a1 libcommon.Address = [20]byte{1}
+		a2 libcommon.Address = [20]byte{2}
+	)
+	logs := Logs{
+		{Index: 0, Address: a1, Topics: []libcommon.Hash{A, B, C}},
+		{Index: 1, Address: a1, Topics: []libcommon.Hash{C, A}},
+		{Index: 2, Address: a1, Topics: []libcommon.Hash{D}},
+		{Index: 3, Address: a2, Topics: []libcommon.Hash{B, A, C}},
+		{Index: 4, Address: a1, Topics: []libcommon.Hash{A, A, A}},
+		{Index: 5, Address: a1},
+	}
+	candidates := []libcommon.Hash{A, B, C}
+	tests := []struct {
+		n       int
+		addrMap map[libcommon.Address]struct{}
+		maxLogs uint64
+		want    []uint
+	}{
+		{1, nil, 0, []uint{0, 1, 3, 4}},
+		{2, nil, 0, []uint{0, 1, 3}},
+		{3, nil, 0, []uint{0, 3}},
+		{3, map[libcommon.Address]struct{}{a1: {}}, 0, []uint{0}},
+		{1, nil, 2, []uint{0, 1}},
+		{4, nil, 0, nil},
+	}
+	for _, tt := range tests {
+		got := testFLExtractIndex(logs.FilterAtLeastNTopics(tt.addrMap, candidates, tt.n, tt.maxLogs))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("n=%d: got %v, want %v", tt.n, got, tt.want)
+		}
+	}
+
+	// n == 1 is the any-topic filter
+	topicsMap := map[libcommon.Hash]struct{}{A: {}, B: {}, C: {}}
+	want := testFLExtractIndex(logs.CointainTopics(nil, topicsMap, 0))
+	if got := testFLExtractIndex(logs.FilterAtLeastNTopics(nil, candidates, 1, 0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("n=1: got %v, CointainTopics returned %v", got, want)
+	}
+}
+
+func TestLogsChannel(t *testing.T) {
+	t.Parallel()
+	logs := make(Logs, 10)
+	for i := range logs {
+		logs[i] = &Log{Index: uint(i)}
+	}
+
+	var got Logs
+	for l := range logs.Channel(context.Background()) {
+		got = append(got, l)
+	}
+	if !reflect.DeepEqual(testFLExtractIndex(got), testFLExtractIndex(logs)) {
+		t.Fatalf("got %v", testFLExtractIndex(got))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := logs.Channel(ctx)
+	for i := 0; i < 3; i++ {
+		if l := <-ch; l.Index != uint(i) {
+			t.Fatalf("got log %d, want %d", l.Index, i)
+		}
+	}
+	cancel()
+	timeout := time.After(5 * time.Second)
+	received := 3
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				if received == len(logs) {
+					t.Fatalf("all logs were sent despite cancellation")
+				}
+				return
+			}
+			received++
+		case <-timeout:
+			t.Fatalf("channel not closed after cancellation")
+		}
+	}
+}
+
+func TestTopicAddressConversion(t *testing.T) {
+	t.Parallel()
+	addr := libcommon.HexToAddress("0x80b2c9d7cbbf30a1b0fc8983c647d754c6525615")
+	topic := libcommon.HexToHash("0x00000000000000000000000080b2c9d7cbbf30a1b0fc8983c647d754c6525615")
+
+	if got := AddressToTopic(addr); got != topic {
+		t.Fatalf("AddressToTopic: got %x, want %x", got, topic)
+	}
+	got, ok := TopicToAddress(topic)
+	if !ok || got != addr {
+		t.Fatalf("TopicToAddress: got %x %v, want %x", got, ok, addr)
+	}
+	if got, ok := TopicToAddress(libcommon.Hash{}); !ok || got != (libcommon.Address{}) {
+		t.Fatalf("zero topic: got %x %v", got, ok)
+	}
+	for i := 0; i < 12; i++ {
+		dirty := topic
+		dirty[i] = 1
+		if _, ok := TopicToAddress(dirty); ok {
+			t.Fatalf("non-zero padding byte %d accepted", i)
+		}
+	}
+	// a keccak topic such as an event signature is not an address
+	if _, ok := TopicToAddress(libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")); ok {
+		t.Fatalf("event signature accepted as address")
+	}
+}
+
+func TestFilterPairs(t *testing.T) {
+	t.Parallel()
+	var (
+		transfer libcommon.Hash = [32]byte{1}
+		approval libcommon.Hash = [32]byte{2}
+
+		token libcommon.Address = [20]byte{1}
+		nft   libcommon.Address = [20]byte{2}
+	)
+	logs := Logs{
+		{Index: 0, Address: token, Topics: []libcommon.Hash{transfer}},
+		{Index: 1, Address: token, Topics: []libcommon.Hash{approval}},
+		{Index: 2, Address: nft, Topics: []libcommon.Hash{transfer}},
+		{Index: 3, Address: nft, Topics: []libcommon.Hash{approval}},
+		{Index: 4, Address: token},
+	}
+	pairs := map[AddrSig]struct{}{
+		{Address: token, Sig: transfer}: {},
+		{Address: nft, Sig: approval}:   {},
+	}
+	if got := testFLExtractIndex(logs.FilterPairs(pairs, 0)); !reflect.DeepEqual(got, []uint{0, 3}) {
+		t.Fatalf("got %v", got)
+	}
+	if got := testFLExtractIndex(logs.FilterPairs(pairs, 1)); !reflect.DeepEqual(got, []uint{0}) {
+		t.Fatalf("maxLogs: got %v", got)
+	}
+
+	// the cross product of the same addresses and signatures over-matches
+	cross := logs.Filter(
+		map[libcommon.Address]struct{}{token: {}, nft: {}},
+		[][]libcommon.Hash{{transfer, approval}},
+		0,
+	)
+	if got := testFLExtractIndex(cross); !reflect.DeepEqual(got, []uint{0, 1, 2, 3}) {
+		t.Fatalf("cross product: got %v", got)
+	}
+}
+
+func TestLogsStats(t *testing.T) {
+	t.Parallel()
+	var (
+		transfer libcommon.Hash    = [32]byte{1}
+		approval libcommon.Hash    = [32]byte{2}
+		a1       libcommon.Address = [20]byte{1}
+		a2       libcommon.Address = [20]byte{2}
+	)
+	logs := Logs{
+		{Address: a1, Topics: []libcommon.Hash{transfer, approval}, Data: make([]byte, 32), BlockNumber: 12},
+		{Address: a2, Topics: []libcommon.Hash{transfer}, Data: make([]byte, 64), BlockNumber: 10},
+		{Address: a1, Topics: []libcommon.Hash{approval}, BlockNumber: 15},
+		{Address: a2, Data: []byte{1}, BlockNumber: 11},
+	}
+	want := LogStats{
+		Count:            4,
+		UniqueAddresses:  2,
+		UniqueSignatures: 2,
+		TotalDataBytes:   97,
+		BlockSpan:        [2]uint64{10, 15},
+	}
+	if got := logs.Stats(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got := (Logs{}).Stats(); got != (LogStats{}) {
+		t.Fatalf("empty logs: got %+v", got)
+	}
+}
+
+func TestLogsLatestPerPair(t *testing.T) {
+	t.Parallel()
+	var (
+		priceUpdate libcommon.Hash    = [32]byte{1}
+		heartbeat   libcommon.Hash    = [32]byte{2}
+		oracleA     libcommon.Address = [20]byte{1}
+		oracleB     libcommon.Address = [20]byte{2}
+	)
+	mk := func(addr libcommon.Address, sig libcommon.Hash, block uint64, index uint) *Log {
+		return &Log{Address: addr, Topics: []libcommon.Hash{sig}, BlockNumber: block, Index: index}
+	}
+	logs := Logs{
+		mk(oracleA, priceUpdate, 1, 0),
+		mk(oracleB, priceUpdate, 1, 1),
+		mk(oracleA, heartbeat, 1, 2),
+		mk(oracleA, priceUpdate, 2, 0),
+		mk(oracleB, priceUpdate, 3, 0),
+		{Address: oracleA, BlockNumber: 4},
+		mk(oracleA, priceUpdate, 3, 5),
+		mk(oracleB, priceUpdate, 3, 1),
+	}
+	got := logs.LatestPerPair()
+	want := Logs{logs[2], logs[7], logs[6]}
+	if len(got) != len(want) {
+		t.Fatalf("got %d logs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("position %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestErigonLogRLP(t *testing.T) {
+	t.Parallel()
+	for name, l := range map[string]*ErigonLog{
+		"timestamp": {
+			Address:   libcommon.HexToAddress("0xecf8f87f810ecf450940c9f60066b4a7a501d6a7"),
+			Topics:    []libcommon.Hash{libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")},
+			Data:      []byte{1, 2, 3},
+			Timestamp: 0x57a53d3a,
+		},
+		"zero timestamp": {
+			Address: libcommon.HexToAddress("0xecf8f87f810ecf450940c9f60066b4a7a501d6a7"),
+			Topics:  []libcommon.Hash{},
+			Data:    []byte{},
+		},
+	} {
+		enc, err := rlp.EncodeToBytes(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dec ErigonLog
+		if err := rlp.DecodeBytes(enc, &dec); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(&dec, l) {
+			t.Fatalf("%s: got %+v, want %+v", name, dec, l)
+		}
+
+		// the encoding differs from the one of the plain log
+		plain, err := rlp.EncodeToBytes(&Log{Address: l.Address, Topics: l.Topics, Data: l.Data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(enc, plain) {
+			t.Fatalf("%s: ErigonLog encoded like Log", name)
+		}
+	}
+}
+
+func TestFilterPredCombinators(t *testing.T) {
+	t.Parallel()
+	var (
+		transfer libcommon.Hash    = [32]byte{1}
+		approval libcommon.Hash    = [32]byte{2}
+		token    libcommon.Address = [20]byte{1}
+		nft      libcommon.Address = [20]byte{2}
+	)
+	logs := Logs{
+		{Index: 0, Address: token, Topics: []libcommon.Hash{transfer}, Data: make([]byte, 32)},
+		{Index: 1, Address: token, Topics: []libcommon.Hash{approval}, Data: make([]byte, 32)},
+		{Index: 2, Address: nft, Topics: []libcommon.Hash{transfer}},
+		{Index: 3, Address: nft, Topics: []libcommon.Hash{approval, transfer}, Data: make([]byte, 64)},
+		{Index: 4, Address: token},
+	}
+	isToken := ByAddress(map[libcommon.Address]struct{}{token: {}})
+	isTransfer := ByTopicAt(0, transfer)
+	oneWord := ByDataLen(32, 32)
+
+	tests := map[string]struct {
+		pred    func(*Log) bool
+		maxLogs uint64
+		want    []uint
+	}{
+		"address":                   {isToken, 0, []uint{0, 1, 4}},
+		"empty address set":         {ByAddress(nil), 0, []uint{0, 1, 2, 3, 4}},
+		"topic at 1":                {ByTopicAt(1, transfer), 0, []uint{3}},
+		"topic out of range":        {ByTopicAt(-1, transfer), 0, nil},
+		"token and transfer":        {AndFilters(isToken, isTransfer), 0, []uint{0}},
+		"token or transfer":         {OrFilters(isToken, isTransfer), 0, []uint{0, 1, 2, 4}},
+		"not token":                 {NotFilter(isToken), 0, []uint{2, 3}},
+		"transfer and not 1 word":   {AndFilters(isTransfer, NotFilter(oneWord)), 0, []uint{2}},
+		"(token and 1 word) or nft": {OrFilters(AndFilters(isToken, oneWord), NotFilter(isToken)), 0, []uint{0, 1, 2, 3}},
+		"nested with limit":         {OrFilters(AndFilters(isToken, oneWord), NotFilter(isToken)), 3, []uint{0, 1, 2}},
+		"empty and":                 {AndFilters(), 0, []uint{0, 1, 2, 3, 4}},
+		"empty or":                  {OrFilters(), 0, nil},
+	}
+	for name, tt := range tests {
+		if got := testFLExtractIndex(logs.FilterPred(tt.pred, tt.maxLogs)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestLogsFramed(t *testing.T) {
+	t.Parallel()
+	frames := []Logs{
+		{
+			{Address: libcommon.Address{1}, Topics: []libcommon.Hash{{2}, {3}}, Data: []byte{4, 5}},
+			{Address: libcommon.Address{6}},
+		},
+		{},
+		{{Address: libcommon.Address{7}, Topics: []libcommon.Hash{{8}}, Data: make([]byte, 100)}},
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		for _, logs := range frames {
+			if err := logs.WriteFramed(w); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		w.Close()
+	}()
+
+	for i, want := range frames {
+		got, err := ReadFramedLogs(r)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("frame %d: got %d logs, want %d", i, len(got), len(want))
+		}
+		for j := range want {
+			if got[j].Address != want[j].Address || !slices.Equal(got[j].Topics, want[j].Topics) || !bytes.Equal(got[j].Data, want[j].Data) {
+				t.Errorf("frame %d log %d: got %v, want %v", i, j, got[j], want[j])
+			}
+		}
+	}
+	if _, err := ReadFramedLogs(r); !errors.Is(err, io.EOF) {
+		t.Errorf("after last frame: got %v, want io.EOF", err)
+	}
+
+	var buf bytes.Buffer
+	if err := frames[0].WriteFramed(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFramedLogs(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated frame: got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := ReadFramedLogs(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})); !errors.Is(err, ErrLogsFrameTooLarge) {
+		t.Errorf("oversized frame: got %v, want ErrLogsFrameTooLarge", err)
+	}
+}
+
+func TestLogDataWord(t *testing.T) {
+	t.Parallel()
+	data := make([]byte, 2*32+5)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	l := &Log{Data: data}
+
+	for _, i := range []int{0, 1} {
+		w, ok := l.DataWord(i)
+		if !ok || !bytes.Equal(w[:], data[i*32:(i+1)*32]) {
+			t.Errorf("word %d: got %x, %v", i, w, ok)
+		}
+	}
+	// word 2 would start inside the 5 trailing bytes
+	for _, i := range []int{-1, 2, 3} {
+		if w, ok := l.DataWord(i); ok || w != (libcommon.Hash{}) {
+			t.Errorf("word %d: got %x, %v, want zero, false", i, w, ok)
+		}
+	}
+	if _, ok := (&Log{}).DataWord(0); ok {
+		t.Error("empty data: got ok")
+	}
+}
+
+func TestReconcileRemoved(t *testing.T) {
+	t.Parallel()
+	var (
+		canonical10 = libcommon.Hash{0xa}
+		orphan10    = libcommon.Hash{0xb}
+		canonical11 = libcommon.Hash{0xc}
+	)
+	logs := Logs{
+		{BlockNumber: 10, BlockHash: canonical10, Index: 0, Removed: true}, // stale flag
+		{BlockNumber: 10, BlockHash: orphan10, Index: 1},                   // orphaned fork
+		{BlockNumber: 11, BlockHash: canonical11, Index: 2},
+		{BlockNumber: 12, BlockHash: libcommon.Hash{0xd}, Index: 3}, // unknown block
+	}
+	got := logs.ReconcileRemoved(map[uint64]libcommon.Hash{10: canonical10, 11: canonical11})
+
+	want := []bool{false, true, false, true}
+	for i, l := range got {
+		if l.Removed != want[i] {
+			t.Errorf("log %d: Removed = %v, want %v", i, l.Removed, want[i])
+		}
+		if l == logs[i] {
+			t.Errorf("log %d: not copied", i)
+		}
+	}
+	if !logs[0].Removed || logs[1].Removed {
+		t.Error("input logs were modified")
+	}
+}
+
+func TestRLPLogCodec(t *testing.T) {
+	t.Parallel()
+	var codec LogCodec = RLPLogCodec{}
+	l := &Log{
+		Address:     libcommon.Address{1},
+		Topics:      []libcommon.Hash{{2}, {3}},
+		Data:        []byte{4, 5, 6},
+		BlockNumber: 7, // not part of the storage encoding
+	}
+	enc, err := codec.Encode(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := rlp.EncodeToBytes((*LogForStorage)(l))
+	if !bytes.Equal(enc, want) {
+		t.Errorf("encoding: got %x, want %x", enc, want)
+	}
+	dec, err := codec.Decode(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Address != l.Address || !slices.Equal(dec.Topics, l.Topics) || !bytes.Equal(dec.Data, l.Data) || dec.BlockNumber != 0 {
+		t.Errorf("decoded %v, want consensus fields of %v", dec, l)
+	}
+	if _, err := codec.Decode(enc[:len(enc)-1]); !errors.Is(err, ErrLogTruncated) {
+		t.Errorf("truncated: got %v, want ErrLogTruncated", err)
+	}
+
+	// the logs of a transaction are stored as a list of codec encodings
+	stored, err := EncodeStoredLogs(codec, Logs{l, l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := DecodeStoredLogs(codec, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || !reflect.DeepEqual(logs[0], dec) || !reflect.DeepEqual(logs[1], dec) {
+		t.Errorf("stored logs decoded to %v", logs)
+	}
+	bad, _ := rlp.EncodeToBytes([][]byte{enc, enc[:len(enc)-1]})
+	if _, err := DecodeStoredLogs(codec, bad); !errors.Is(err, ErrLogTruncated) {
+		t.Errorf("truncated stored log: got %v, want ErrLogTruncated", err)
+	}
+}
+
+func TestCBORLogCodec(t *testing.T) {
+	t.Parallel()
+	var codec LogCodec = CBORLogCodec{}
+	l := &Log{
+		Address:     libcommon.Address{1},
+		Topics:      []libcommon.Hash{{2}, {3}},
+		Data:        []byte{4, 5, 6},
+		BlockNumber: 7, // not part of the storage encoding
+	}
+	enc, err := codec.Encode(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := codec.Decode(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Address != l.Address || !slices.Equal(dec.Topics, l.Topics) || !bytes.Equal(dec.Data, l.Data) || dec.BlockNumber != 0 {
+		t.Errorf("decoded %v, want consensus fields of %v", dec, l)
+	}
+
+	// the logs of a transaction are stored as one CBOR array, as in the kv.Log table
+	var want bytes.Buffer
+	if err := cbor.Marshal(&want, Logs{l, l}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := EncodeStoredLogs(codec, Logs{l, l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, want.Bytes()) {
+		t.Errorf("stored logs: got %x, want %x", stored, want.Bytes())
+	}
+	logs, err := DecodeStoredLogs(codec, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || !reflect.DeepEqual(logs[0], dec) || !reflect.DeepEqual(logs[1], dec) {
+		t.Errorf("stored logs decoded to %v", logs)
+	}
+}
+
+func TestFilterDistinctTopics(t *testing.T) {
+	t.Parallel()
+	var (
+		x libcommon.Hash = [32]byte{1}
+		y libcommon.Hash = [32]byte{2}
+	)
+	logs := Logs{
+		{Index: 0, Topics: []libcommon.Hash{x, x}},
+		{Index: 1, Topics: []libcommon.Hash{x, y}},
+		{Index: 2, Topics: []libcommon.Hash{x, y, x}},
+		{Index: 3, Topics: []libcommon.Hash{y}},
+		{Index: 4, Topics: []libcommon.Hash{x, y, y}},
+	}
+	tests := map[string]struct {
+		topics          [][]libcommon.Hash
+		requireDistinct bool
+		maxLogs         uint64
+		want            []uint
+	}{
+		"x at 0 and 1":                {[][]libcommon.Hash{{x}, {x}}, false, 0, []uint{0}},
+		"x at 0 and 1, distinct":      {[][]libcommon.Hash{{x}, {x}}, true, 0, nil},
+		"x at 0":                      {[][]libcommon.Hash{{x}}, false, 0, []uint{0, 1, 2, 4}},
+		"x at 0, distinct":            {[][]libcommon.Hash{{x}}, true, 0, []uint{1}},
+		"any, distinct":               {nil, true, 0, []uint{1, 3}},
+		"any, distinct, limited":      {nil, true, 1, []uint{1}},
+		"repeat past filtered prefix": {[][]libcommon.Hash{{x}, {y}}, true, 0, []uint{1}},
+		"repeat past prefix, allowed": {[][]libcommon.Hash{{x}, {y}}, false, 0, []uint{1, 2, 4}},
+		"limit counts returned logs":  {[][]libcommon.Hash{{x}, {y}}, false, 2, []uint{1, 2}},
+	}
+	for name, tt := range tests {
+		got := testFLExtractIndex(logs.FilterDistinctTopics(nil, tt.topics, tt.requireDistinct, tt.maxLogs))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestAssembleFromTxContexts(t *testing.T) {
+	t.Parallel()
+	blockHash := libcommon.Hash{0xbb}
+	ctxs := map[uint]TxLogContext{
+		0: {TxHash: libcommon.Hash{0xa0}, LogIndexBase: 0},
+		2: {TxHash: libcommon.Hash{0xa2}, LogIndexBase: 2},
+		3: {TxHash: libcommon.Hash{0xa3}, LogIndexBase: 5},
+	}
+	logs := Logs{
+		{TxIndex: 0}, {TxIndex: 0},
+		{TxIndex: 2}, {TxIndex: 2}, {TxIndex: 2},
+		{TxIndex: 3},
+		{TxIndex: 7, Index: 42}, // unknown transaction
+	}
+	if err := logs.AssembleFromTxContexts(100, blockHash, ctxs); err != nil {
+		t.Fatal(err)
+	}
+
+	wantIndex := []uint{0, 1, 2, 3, 4, 5, 42}
+	for i, l := range logs {
+		if l.BlockNumber != 100 || l.BlockHash != blockHash {
+			t.Errorf("log %d: block fields %d %x", i, l.BlockNumber, l.BlockHash)
+		}
+		if l.Index != wantIndex[i] {
+			t.Errorf("log %d: Index = %d, want %d", i, l.Index, wantIndex[i])
+		}
+		if ctx, ok := ctxs[l.TxIndex]; ok && l.TxHash != ctx.TxHash {
+			t.Errorf("log %d: TxHash = %x, want %x", i, l.TxHash, ctx.TxHash)
+		}
+	}
+	if logs[6].TxHash != (libcommon.Hash{}) {
+		t.Errorf("log of unknown transaction got TxHash %x", logs[6].TxHash)
+	}
+	// indices are consecutive within each transaction
+	for i := 1; i < len(logs)-1; i++ {
+		if logs[i].TxIndex == logs[i-1].TxIndex && logs[i].Index != logs[i-1].Index+1 {
+			t.Errorf("log %d: index %d doesn't follow %d", i, logs[i].Index, logs[i-1].Index)
+		}
+	}
+}
+
+func TestFilterByWordCount(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Index: 0},
+		{Index: 1, Data: make([]byte, 32)},
+		{Index: 2, Data: make([]byte, 33)}, // not word aligned
+		{Index: 3, Data: make([]byte, 64)},
+		{Index: 4, Data: make([]byte, 31)},
+		{Index: 5, Data: []byte{}},
+	}
+	tests := map[string]struct {
+		words   int
+		maxLogs uint64
+		want    []uint
+	}{
+		"no data":   {0, 0, []uint{0, 5}},
+		"one word":  {1, 0, []uint{1}},
+		"two words": {2, 0, []uint{3}},
+		"unbounded": {-1, 0, []uint{0, 1, 2, 3, 4, 5}},
+		"limited":   {-1, 2, []uint{0, 1}},
+		"none":      {3, 0, nil},
+	}
+	for name, tt := range tests {
+		if got := testFLExtractIndex(logs.FilterByWordCount(tt.words, tt.maxLogs)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestLogCopyBounded(t *testing.T) {
+	t.Parallel()
+	l := &Log{Address: libcommon.Address{1}, Topics: make([]libcommon.Hash, 5000), Data: []byte{1}}
+	if cp, err := l.CopyBounded(maxLogTopics); !errors.Is(err, ErrTooManyTopics) || cp != nil {
+		t.Fatalf("got %v, %v, want ErrTooManyTopics", cp, err)
+	}
+
+	l.Topics = l.Topics[:maxLogTopics]
+	cp, err := l.CopyBounded(maxLogTopics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cp, l.Copy()) {
+		t.Fatalf("got %v, want %v", cp, l.Copy())
+	}
+	if cp, err := (*Log)(nil).CopyBounded(0); cp != nil || err != nil {
+		t.Fatalf("nil log: got %v, %v", cp, err)
+	}
+}
+
+func TestLogMarshalJSONDeterministic(t *testing.T) {
+	t.Parallel()
+	l := &Log{
+		Address:     libcommon.HexToAddress("0x01"),
+		Topics:      []libcommon.Hash{libcommon.HexToHash("0x02")},
+		Data:        []byte{3},
+		BlockNumber: 4,
+		TxHash:      libcommon.HexToHash("0x05"),
+		TxIndex:     6,
+		BlockHash:   libcommon.HexToHash("0x07"),
+		Index:       8,
+		Removed:     true,
+	}
+	first, err := l.MarshalJSONDeterministic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.Copy().MarshalJSONDeterministic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatalf("marshals differ:\n%s\n%s", first, second)
+	}
+	const want = `{"address":"0x0000000000000000000000000000000000000001",` +
+		`"topics":["0x0000000000000000000000000000000000000000000000000000000000000002"],` +
+		`"data":"0x03","blockNumber":"0x4",` +
+		`"transactionHash":"0x0000000000000000000000000000000000000000000000000000000000000005",` +
+		`"transactionIndex":"0x6",` +
+		`"blockHash":"0x0000000000000000000000000000000000000000000000000000000000000007",` +
+		`"logIndex":"0x8","removed":true}`
+	if string(first) != want {
+		t.Fatalf("got %s\nwant %s", first, want)
+	}
+
+	nilTopics, _ := (&Log{}).MarshalJSONDeterministic()
+	emptyTopics, _ := (&Log{Topics: []libcommon.Hash{}}).MarshalJSONDeterministic()
+	if !bytes.Equal(nilTopics, emptyTopics) {
+		t.Fatalf("nil and empty topics differ:\n%s\n%s", nilTopics, emptyTopics)
+	}
+}
+
+func TestLogHexRLP(t *testing.T) {
+	t.Parallel()
+	l := &Log{Address: libcommon.Address{1}, Topics: []libcommon.Hash{{2}, {3}}, Data: []byte{4, 5}}
+	enc := l.ToHexRLP()
+	if !strings.HasPrefix(enc, "0x") {
+		t.Fatalf("missing 0x prefix: %s", enc)
+	}
+	for _, in := range []string{enc, enc[2:], strings.ToUpper(enc[2:])} {
+		dec, err := LogFromHexRLP(in)
+		if err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if dec.Address != l.Address || !slices.Equal(dec.Topics, l.Topics) || !bytes.Equal(dec.Data, l.Data) {
+			t.Fatalf("%s: got %v, want %v", in, dec, l)
+		}
+		if dec.ToHexRLP() != enc {
+			t.Fatalf("re-encoded as %s, want %s", dec.ToHexRLP(), enc)
+		}
+	}
+
+	for in, want := range map[string]string{
+		"0xzz":           "invalid log hex",
+		"0xc":            "invalid log hex",
+		"0xc0":           "invalid log rlp",
+		enc[:len(enc)-2]: "invalid log rlp",
+	} {
+		if _, err := LogFromHexRLP(in); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", in, err, want)
+		}
+	}
+	if _, err := LogFromHexRLP(enc[:len(enc)-2]); !errors.Is(err, ErrLogTruncated) {
+		t.Errorf("truncated input: got %v, want ErrLogTruncated", err)
+	}
+}
+
+func TestLogDecodeIndexed(t *testing.T) {
+	t.Parallel()
+	addr := libcommon.HexToAddress("0x00000000219ab540356cbb839cbe05303d7705fa")
+	value := libcommon.HexToHash("0x0de0b6b3a7640000") // 1e18
+	dirty := AddressToTopic(addr)
+	dirty[0] = 1
+	l := &Log{Topics: []libcommon.Hash{AddressToTopic(addr), value, dirty}}
+
+	if got := TopicAsAddress(l.Topics[0]); got != addr {
+		t.Errorf("TopicAsAddress: got %x, want %x", got, addr)
+	}
+	if got := TopicAsAddress(dirty); got != addr {
+		t.Errorf("TopicAsAddress ignores padding: got %x, want %x", got, addr)
+	}
+	if got := TopicAsUint256(value); !got.Eq(uint256.NewInt(1e18)) {
+		t.Errorf("TopicAsUint256: got %s", got)
+	}
+	if got := TopicAsBytes32(value); got != [32]byte(value) {
+		t.Errorf("TopicAsBytes32: got %x", got)
+	}
+
+	tests := []struct {
+		i       int
+		kind    TopicKind
+		want    any
+		wantErr string
+	}{
+		{0, TopicKindAddress, addr, ""},
+		{1, TopicKindUint256, uint256.NewInt(1e18), ""},
+		{1, TopicKindBytes32, [32]byte(value), ""},
+		{2, TopicKindAddress, nil, "non-zero padding"},
+		{2, TopicKindBytes32, [32]byte(dirty), ""},
+		{3, TopicKindBytes32, nil, "out of range"},
+		{-1, TopicKindBytes32, nil, "out of range"},
+		{0, TopicKind(9), nil, "unknown topic kind TopicKind(9)"},
+	}
+	for _, tt := range tests {
+		got, err := l.DecodeIndexed(tt.i, tt.kind)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("topic %d as %s: got error %v, want %q", tt.i, tt.kind, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("topic %d as %s: got %v, %v, want %v", tt.i, tt.kind, got, err, tt.want)
+		}
+	}
+}
+
+func TestLogsValidateOrdering(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		logs    Logs
+		wantErr string
+	}{
+		{"empty", nil, ""},
+		{"single", Logs{{TxIndex: 3, Index: 7}}, ""},
+		{"ordered", Logs{{TxIndex: 0, Index: 0}, {TxIndex: 0, Index: 1}, {TxIndex: 2, Index: 2}, {TxIndex: 5, Index: 9}}, ""},
+		{"repeated index", Logs{{TxIndex: 0, Index: 0}, {TxIndex: 0, Index: 1}, {TxIndex: 1, Index: 1}},
+			"log 2 has index 1, not above index 1 of log 1"},
+		{"decreasing index", Logs{{TxIndex: 0, Index: 4}, {TxIndex: 1, Index: 3}},
+			"log 1 has index 3, not above index 4 of log 0"},
+		{"decreasing tx index", Logs{{TxIndex: 0, Index: 0}, {TxIndex: 2, Index: 1}, {TxIndex: 1, Index: 2}},
+			"log 2 has tx index 1, below tx index 2 of log 1"},
+	}
+	for _, tt := range tests {
+		err := tt.logs.ValidateOrdering()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrLogsMisordered) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLogsConcatDataBySignature(t *testing.T) {
+	t.Parallel()
+	var (
+		part  libcommon.Hash = [32]byte{1}
+		other libcommon.Hash = [32]byte{2}
+	)
+	logs := Logs{
+		{Topics: []libcommon.Hash{part}, Data: []byte("hello ")},
+		{Topics: []libcommon.Hash{other}, Data: []byte("noise")},
+		{Data: []byte("anonymous")},
+		{Topics: []libcommon.Hash{other, part}, Data: []byte("sig in second position")},
+		{Topics: []libcommon.Hash{part}},
+		{Topics: []libcommon.Hash{part, other}, Data: []byte("world")},
+	}
+	if got := logs.ConcatDataBySignature(part); string(got) != "hello world" {
+		t.Errorf("got %q, want %q", got, "hello world")
+	}
+	if got := logs.ConcatDataBySignature(libcommon.Hash{3}); len(got) != 0 {
+		t.Errorf("no match: got %q", got)
+	}
+}
+
+func TestLogsRetentionSplit(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Index: 0, BlockNumber: 98},
+		{Index: 1, BlockNumber: 99},
+		{Index: 2, BlockNumber: 100},
+		{Index: 3, BlockNumber: 100},
+		{Index: 4, BlockNumber: 101},
+	}
+	if got, want := testFLExtractIndex(logs.FilterOlderThan(100)), []uint{0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("older than 100: got %v, want %v", got, want)
+	}
+	if got, want := testFLExtractIndex(logs.FilterNewerOrEqual(100)), []uint{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("newer or equal 100: got %v, want %v", got, want)
+	}
+	if got := logs.FilterOlderThan(0); len(got) != 0 {
+		t.Errorf("older than 0: got %d logs", len(got))
+	}
+	if got := logs.FilterNewerOrEqual(0); len(got) != len(logs) {
+		t.Errorf("newer or equal 0: got %d logs", len(got))
+	}
+}
+
+func TestErigonLogsCopy(t *testing.T) {
+	t.Parallel()
+	ok := true
+	logs := ErigonLogs{
+		{
+			Address:     libcommon.Address{1},
+			Topics:      []libcommon.Hash{{2}},
+			Data:        []byte{3},
+			BlockNumber: 4,
+			TxHash:      libcommon.Hash{5},
+			TxIndex:     6,
+			BlockHash:   libcommon.Hash{7},
+			Index:       8,
+			Removed:     true,
+			Timestamp:   1700000000,
+			TxSuccess:   &ok,
+		},
+		nil,
+	}
+	cp := logs.Copy()
+	if !reflect.DeepEqual(cp, logs) {
+		t.Fatalf("got %v, want %v", cp, logs)
+	}
+
+	cp[0].Topics[0] = libcommon.Hash{0xff}
+	cp[0].Data[0] = 0xff
+	*cp[0].TxSuccess = false
+	cp[0].Timestamp = 0
+	if logs[0].Topics[0] != (libcommon.Hash{2}) || logs[0].Data[0] != 3 || !*logs[0].TxSuccess || logs[0].Timestamp != 1700000000 {
+		t.Fatalf("mutating the copy changed the original: %v", logs[0])
+	}
+	if ErigonLogs(nil).Copy() != nil {
+		t.Fatal("copy of nil logs must be nil")
+	}
+}
+
+func TestLogsNetLogs(t *testing.T) {
+	t.Parallel()
+	var (
+		blockA  = libcommon.Hash{0xa}
+		blockA2 = libcommon.Hash{0xa2} // replaces blockA after the reorg
+		blockB  = libcommon.Hash{0xb}
+		tx1     = libcommon.Hash{1}
+		tx2     = libcommon.Hash{2}
+	)
+	log := func(block, tx libcommon.Hash, index uint, removed bool) *Log {
+		return &Log{BlockHash: block, TxHash: tx, Index: index, Removed: removed}
+	}
+	stream := Logs{
+		log(blockA, tx1, 0, false),
+		log(blockA, tx1, 1, false),
+		log(blockB, tx2, 2, false),
+		// reorg of blockA: its logs are re-sent removed, then the new block's logs arrive
+		log(blockA, tx1, 0, true),
+		log(blockA, tx1, 1, true),
+		log(blockA2, tx1, 0, false),
+		log(blockB, tx2, 2, false), // repeated
+		log(blockB, tx1, 7, true),  // never added
+		log(blockB, tx2, 3, false),
+		log(blockB, tx2, 3, true),
+		log(blockB, tx2, 3, false), // re-added after removal
+	}
+	got := stream.NetLogs()
+	want := Logs{stream[2], stream[5], stream[10]}
+	if len(got) != len(want) {
+		t.Fatalf("got %d logs %v, want %v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("log %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	if got := (Logs{log(blockA, tx1, 0, false), log(blockA, tx1, 0, true)}).NetLogs(); len(got) != 0 {
+		t.Errorf("add then remove: got %v, want nothing", got)
+	}
+}
+
+func TestSignatureRegistryAnnotate(t *testing.T) {
+	t.Parallel()
+	var (
+		transfer = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+		approval = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	)
+	var r SignatureRegistry
+	r.Register(transfer, "Transfer(address,address,uint256)")
+	r.Register(approval, "Approve")
+	r.Register(approval, "Approval(address,address,uint256)") // replaces
+
+	logs := Logs{
+		{Topics: []libcommon.Hash{transfer}},
+		{Topics: []libcommon.Hash{{1}, transfer}}, // signature must be topic 0
+		{},
+		{Topics: []libcommon.Hash{approval}},
+	}
+	got := r.Annotate(logs)
+	want := []string{"Transfer(address,address,uint256)", "", "", "Approval(address,address,uint256)"}
+	if len(got) != len(logs) {
+		t.Fatalf("got %d annotated logs, want %d", len(got), len(logs))
+	}
+	for i := range got {
+		if got[i].Log != logs[i] || got[i].Name != want[i] {
+			t.Errorf("log %d: got %q, want %q", i, got[i].Name, want[i])
+		}
+	}
+	var empty SignatureRegistry
+	if got := empty.Annotate(logs[:1]); got[0].Name != "" {
+		t.Errorf("empty registry: got %q", got[0].Name)
+	}
+}
+
+func TestFilterWithScanCount(t *testing.T) {
+	t.Parallel()
+	var (
+		wanted libcommon.Address = [20]byte{1}
+		other  libcommon.Address = [20]byte{2}
+	)
+	logs := make(Logs, 100)
+	for i := range logs {
+		logs[i] = &Log{Index: uint(i), Address: other}
+	}
+	for _, i := range []int{10, 40, 41, 90} {
+		logs[i].Address = wanted
+	}
+	addrMap := map[libcommon.Address]struct{}{wanted: {}}
+
+	tests := []struct {
+		maxLogs     uint64
+		want        []uint
+		wantScanned int
+	}{
+		{0, []uint{10, 40, 41, 90}, 100},
+		{2, []uint{10, 40}, 41},
+		{4, []uint{10, 40, 41, 90}, 91},
+		{5, []uint{10, 40, 41, 90}, 100},
+	}
+	var ws FilterWorkspace
+	for _, tt := range tests {
+		got, scanned := logs.FilterWithScanCount(addrMap, nil, tt.maxLogs)
+		if !reflect.DeepEqual(testFLExtractIndex(got), tt.want) || scanned != tt.wantScanned {
+			t.Errorf("maxLogs %d: got %v scanning %d, want %v scanning %d", tt.maxLogs, testFLExtractIndex(got), scanned, tt.want, tt.wantScanned)
+		}
+		// the workspace shares the meaning of maxLogs: a limit on returned logs
+		if got := testFLExtractIndex(ws.Filter(logs, addrMap, nil, tt.maxLogs)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("workspace maxLogs %d: got %v, want %v", tt.maxLogs, got, tt.want)
+		}
+	}
+	if got, scanned := (Logs{}).FilterWithScanCount(nil, nil, 0); len(got) != 0 || scanned != 0 {
+		t.Errorf("empty logs: got %v scanning %d", got, scanned)
+	}
+}
+
+func TestLogsSortInterface(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{BlockNumber: 2, Index: 1, Address: libcommon.Address{3}},
+		{BlockNumber: 1, Index: 5, Address: libcommon.Address{1}},
+		{BlockNumber: 2, Index: 0, Address: libcommon.Address{1}},
+		{BlockNumber: 1, Index: 4, Address: libcommon.Address{2}},
+	}
+
+	byBlock := slices.Clone(logs)
+	sort.Stable(byBlock.SortInterface(LessByBlockThenIndex))
+	if want := (Logs{logs[3], logs[1], logs[2], logs[0]}); !reflect.DeepEqual(byBlock, want) {
+		t.Errorf("by block then index: got %v, want %v", byBlock, want)
+	}
+
+	byAddress := slices.Clone(logs)
+	sort.Stable(byAddress.SortInterface(LessByAddress))
+	// logs[1] and logs[2] share an address and keep their relative order
+	if want := (Logs{logs[1], logs[2], logs[3], logs[0]}); !reflect.DeepEqual(byAddress, want) {
+		t.Errorf("by address: got %v, want %v", byAddress, want)
+	}
+}
+
+func TestLogsRemapAddresses(t *testing.T) {
+	t.Parallel()
+	var (
+		oldToken = libcommon.Address{1}
+		newToken = libcommon.Address{2}
+		kept     = libcommon.Address{3}
+	)
+	logs := Logs{
+		{Address: oldToken, Topics: []libcommon.Hash{{9}, AddressToTopic(oldToken)}},
+		{Address: kept},
+	}
+	got := logs.RemapAddresses(map[libcommon.Address]libcommon.Address{oldToken: newToken})
+
+	if got[0].Address != newToken || got[1].Address != kept {
+		t.Errorf("got addresses %x, %x, want %x, %x", got[0].Address, got[1].Address, newToken, kept)
+	}
+	if got[0].Topics[1] != AddressToTopic(oldToken) {
+		t.Errorf("address topic was rewritten: %x", got[0].Topics[1])
+	}
+	if logs[0].Address != oldToken || got[0] == logs[0] || got[1] == logs[1] {
+		t.Error("logs were not copied")
+	}
+}
+
+// compactTestLogs are typical logs: an ERC-20 Transfer, a single-topic event, a log
+// with a zero topic and an anonymous log without data.
+func compactTestLogs() Logs {
+	transfer := libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	return Logs{
+		{
+			Address: libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7"),
+			Topics:  []libcommon.Hash{transfer, AddressToTopic(libcommon.Address{1}), AddressToTopic(libcommon.Address{2})},
+			Data:    libcommon.LeftPadBytes([]byte{0x01, 0x31, 0x2d, 0x00}, 32),
+		},
+		{Address: libcommon.Address{3}, Topics: []libcommon.Hash{transfer}, Data: make([]byte, 96)},
+		{Address: libcommon.Address{4}, Topics: []libcommon.Hash{transfer, {}, {5}}},
+		{Address: libcommon.Address{6}},
+	}
+}
+
+func TestLogCompactEncoding(t *testing.T) {
+	t.Parallel()
+	for i, l := range compactTestLogs() {
+		enc := l.EncodeCompact()
+		dec, err := DecodeCompact(enc)
+		if err != nil {
+			t.Fatalf("log %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(dec, &Log{Address: l.Address, Topics: l.Topics, Data: l.Data}) {
+			t.Errorf("log %d: got %v, want %v", i, dec, l)
+		}
+		if rlpEnc, _ := rlp.EncodeToBytes(l); len(enc) > len(rlpEnc) {
+			t.Errorf("log %d: compact encoding is %d bytes, rlp %d", i, len(enc), len(rlpEnc))
+		}
+		for n := 0; n < len(enc); n++ {
+			if _, err := DecodeCompact(enc[:n]); !errors.Is(err, ErrLogTruncated) {
+				t.Fatalf("log %d truncated to %d bytes: got %v, want ErrLogTruncated", i, n, err)
+			}
+		}
+		if _, err := DecodeCompact(append(enc, 0)); !errors.Is(err, ErrMalformedCompactLog) {
+			t.Errorf("log %d with trailing byte: got %v, want ErrMalformedCompactLog", i, err)
+		}
+	}
+
+	// zero topics are skipped: header, address, one topic, data length
+	if got := len((&Log{Topics: []libcommon.Hash{{}, {}, {1}, {}}}).EncodeCompact()); got != 1+20+32+1 {
+		t.Errorf("sparse topics encoded in %d bytes", got)
+	}
+	for _, header := range []byte{0x50, 0x12, 0x80} { // 5 topics, mask beyond count, high bit
+		if _, err := DecodeCompact(append([]byte{header}, make([]byte, 60)...)); !errors.Is(err, ErrMalformedCompactLog) {
+			t.Errorf("header %#02x: got %v, want ErrMalformedCompactLog", header, err)
+		}
+	}
+}
+
+func BenchmarkLogEncodeCompact(b *testing.B) {
+	logs := compactTestLogs()
+	var compactSize, rlpSize int
+	for _, l := range logs {
+		compactSize += len(l.EncodeCompact())
+		enc, _ := rlp.EncodeToBytes(l)
+		rlpSize += len(enc)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logs[i%len(logs)].EncodeCompact()
+	}
+	b.ReportMetric(float64(compactSize)/float64(len(logs)), "compact-B/log")
+	b.ReportMetric(float64(rlpSize)/float64(len(logs)), "rlp-B/log")
+}
+
+func TestFilterAddressInTopics(t *testing.T) {
+	t.Parallel()
+	var (
+		transfer = libcommon.Hash{0xee}
+		wallet   = libcommon.HexToAddress("0x71c7656ec7ab88b098defb751b7401b5f6d8976f")
+		other    = libcommon.Address{1}
+	)
+	logs := Logs{
+		{Index: 0, Topics: []libcommon.Hash{transfer, AddressToTopic(wallet), AddressToTopic(other)}}, // from
+		{Index: 1, Topics: []libcommon.Hash{transfer, AddressToTopic(other), AddressToTopic(wallet)}}, // to
+		{Index: 2, Topics: []libcommon.Hash{transfer, AddressToTopic(other), AddressToTopic(other)}},
+		{Index: 3, Address: wallet, Topics: []libcommon.Hash{transfer}}, // emitted by, not in topics
+		{Index: 4, Topics: []libcommon.Hash{AddressToTopic(wallet)}},
+		{Index: 5, Topics: []libcommon.Hash{libcommon.BytesToHash(append(wallet[:], make([]byte, 12)...))}}, // not left-padded
+		{Index: 6, Topics: []libcommon.Hash{transfer, {}, {}, AddressToTopic(wallet)}},
+	}
+	if got, want := testFLExtractIndex(logs.FilterAddressInTopics(wallet, 0)), []uint{0, 1, 4, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := testFLExtractIndex(logs.FilterAddressInTopics(wallet, 2)), []uint{0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("limited: got %v, want %v", got, want)
+	}
+}
+
+func TestLogsTxRelativeIndices(t *testing.T) {
+	t.Parallel()
+	var (
+		tx1 = libcommon.Hash{1}
+		tx2 = libcommon.Hash{2}
+		tx3 = libcommon.Hash{3}
+	)
+	logs := Logs{
+		{TxHash: tx1, Index: 0},
+		{TxHash: tx1, Index: 1},
+		{TxHash: tx1, Index: 2},
+		{TxHash: tx2, Index: 3},
+		{TxHash: tx3, Index: 4},
+		{TxHash: tx3, Index: 5},
+	}
+	want := map[libcommon.Hash][]uint{
+		tx1: {0, 1, 2},
+		tx2: {0},
+		tx3: {0, 1},
+	}
+	if got := logs.TxRelativeIndices(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// positions follow the order of logs, not of Index
+	shuffled := Logs{logs[5], logs[0], logs[4], logs[2], logs[1]}
+	if got := shuffled.TxRelativeIndices(); !reflect.DeepEqual(got[tx1], []uint{0, 2, 1}) || !reflect.DeepEqual(got[tx3], []uint{1, 0}) {
+		t.Errorf("shuffled: got %v", got)
+	}
+}
+
+func TestLogsWindowByBlock(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Index: 0, BlockNumber: 3},
+		{Index: 1, BlockNumber: 99},
+		{Index: 2, BlockNumber: 100},
+		{Index: 3, BlockNumber: 100},
+		{Index: 4, BlockNumber: 199},
+		// blocks 200 to 499 hold no logs
+		{Index: 5, BlockNumber: 500},
+		{Index: 6, BlockNumber: 650},
+	}
+	type window struct {
+		start   uint64
+		indices []uint
+	}
+	var got []window
+	logs.WindowByBlock(100, func(start uint64, w Logs) {
+		got = append(got, window{start, testFLExtractIndex(w)})
+	})
+	want := []window{
+		{0, []uint{0, 1}},
+		{100, []uint{2, 3, 4}},
+		{500, []uint{5}},
+		{600, []uint{6}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	Logs(nil).WindowByBlock(10, func(uint64, Logs) { t.Error("called for no logs") })
+}
+
+func TestDecorate(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Address: libcommon.Address{1}, Index: 0},
+		{Address: libcommon.Address{2}, Index: 1},
+	}
+	decorated := Decorate(logs)
+	if len(decorated) != len(logs) {
+		t.Fatalf("got %d decorated logs, want %d", len(decorated), len(logs))
+	}
+	for i, d := range decorated {
+		if d.Log != logs[i] || d.Meta == nil || len(d.Meta) != 0 {
+			t.Fatalf("log %d: got %v with meta %v", i, d.Log, d.Meta)
+		}
+	}
+
+	decorated[0].Set("amount", uint256.NewInt(1000))
+	decorated[0].Set("symbol", "USDT")
+	decorated[0].Set("symbol", "USDC")
+	if got := decorated[0].Meta["amount"].(*uint256.Int); !got.Eq(uint256.NewInt(1000)) {
+		t.Errorf("amount: got %v", got)
+	}
+	if got := decorated[0].Meta["symbol"]; got != "USDC" {
+		t.Errorf("symbol: got %v", got)
+	}
+	if len(decorated[1].Meta) != 0 {
+		t.Errorf("metadata leaked to another log: %v", decorated[1].Meta)
+	}
+	// the embedded log is reachable directly
+	if decorated[1].Address != (libcommon.Address{2}) {
+		t.Errorf("embedded address: got %x", decorated[1].Address)
+	}
+
+	var zero DecoratedLog
+	zero.Set("k", 1)
+	if zero.Meta["k"] != 1 {
+		t.Errorf("zero value: got %v", zero.Meta)
+	}
+}
+
+func TestFilterDataContains(t *testing.T) {
+	t.Parallel()
+	pattern := []byte{0xde, 0xad, 0xbe, 0xef}
+	logs := Logs{
+		{Index: 0, Data: []byte{0xde, 0xad, 0xbe, 0xef, 0x00}},             // start
+		{Index: 1, Data: []byte{0x00, 0x01, 0xde, 0xad, 0xbe, 0xef, 0x02}}, // middle
+		{Index: 2, Data: []byte{0x00, 0xde, 0xad, 0xbe, 0xef}},             // end
+		{Index: 3, Data: []byte{0xde, 0xad, 0xbe}},                         // partial
+		{Index: 4},
+	}
+	tests := map[string]struct {
+		pattern []byte
+		maxLogs uint64
+		want    []uint
+	}{
+		"pattern":       {pattern, 0, []uint{0, 1, 2}},
+		"limited":       {pattern, 1, []uint{0}},
+		"absent":        {[]byte{0xca, 0xfe}, 0, nil},
+		"empty pattern": {nil, 0, []uint{0, 1, 2, 3, 4}},
+	}
+	for name, tt := range tests {
+		if got := testFLExtractIndex(logs.FilterDataContains(tt.pattern, tt.maxLogs)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestLogsSetTxSuccess(t *testing.T) {
+	t.Parallel()
+	var (
+		ok      = libcommon.Hash{1}
+		failed  = libcommon.Hash{2}
+		unknown = libcommon.Hash{3}
+		stale   = false
+	)
+	logs := Logs{
+		{Index: 0, TxHash: ok},
+		{Index: 1, TxHash: failed},
+		{Index: 2, TxHash: ok},
+		{Index: 3, TxHash: unknown, TxSuccess: &stale},
+	}
+	logs.SetTxSuccess(map[libcommon.Hash]bool{ok: true, failed: false})
+
+	want := []bool{true, false, true, true}
+	for i, l := range logs {
+		if l.Succeeded() != want[i] {
+			t.Errorf("log %d: Succeeded() = %v, want %v", i, l.Succeeded(), want[i])
+		}
+	}
+	if logs[0].TxSuccess == nil || logs[3].TxSuccess != nil {
+		t.Errorf("known status must be set explicitly and unknown cleared: %v, %v", logs[0].TxSuccess, logs[3].TxSuccess)
+	}
+	// each log gets its own status
+	*logs[0].TxSuccess = false
+	if !*logs[2].TxSuccess {
+		t.Error("logs share their TxSuccess")
+	}
+	if got, want := testFLExtractIndex(logs.FilterSuccessfulOnly()), []uint{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("successful logs: got %v, want %v", got, want)
+	}
+}
+
+func TestTokenEventSignatures(t *testing.T) {
+	t.Parallel()
+	for sig, want := range map[string]libcommon.Hash{
+		"Transfer(address,address,uint256)":                          TransferSig,
+		"Approval(address,address,uint256)":                          ApprovalSig,
+		"ApprovalForAll(address,address,bool)":                       ApprovalForAllSig,
+		"TransferSingle(address,address,address,uint256,uint256)":    TransferSingleSig,
+		"TransferBatch(address,address,address,uint256[],uint256[])": TransferBatchSig,
+	} {
+		if got := crypto.Keccak256Hash([]byte(sig)); got != want {
+			t.Errorf("%s: constant %x, keccak256 %x", sig, want, got)
+		}
+	}
+
+	// Transfer of 0x304a554a310c7e546dfe434669c62820b7d83490 in mainnet block 1881284,
+	// as in the call_tracer_withLog/multi_contracts tracer test.
+	erc20 := &Log{
+		Address: libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490"),
+		Topics: []libcommon.Hash{
+			libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"),
+			libcommon.HexToHash("0x000000000000000000000000c0ee9db1a9e07ca63e4ff0d5fb6f86bf68d47b89"),
+			libcommon.HexToHash("0x0000000000000000000000004fd27b205895e698fa350f7ea57cec8a21927fcd"),
+		},
+		Data: libcommon.FromHex("0x00000000000000000000000000000000000000000001819451f999d617dafa93"),
+	}
+	erc721 := &Log{Topics: []libcommon.Hash{TransferSig, {1}, {2}, {3}}}
+	approval := &Log{Topics: []libcommon.Hash{ApprovalSig, {1}, {2}}, Data: make([]byte, 32)}
+	// an ERC-20 Transfer with the amount indexed, as emitted by some non-standard tokens
+	oddTransfer := &Log{Topics: []libcommon.Hash{TransferSig, {1}, {2}, {3}}, Data: make([]byte, 32)}
+
+	for _, tt := range []struct {
+		name          string
+		l             *Log
+		erc20, erc721 bool
+	}{
+		{"mainnet erc20", erc20, true, false},
+		{"erc721", erc721, false, true},
+		{"approval", approval, false, false},
+		{"odd transfer", oddTransfer, false, false},
+		{"anonymous", &Log{}, false, false},
+	} {
+		if got := IsERC20Transfer(tt.l); got != tt.erc20 {
+			t.Errorf("%s: IsERC20Transfer = %v", tt.name, got)
+		}
+		if got := IsERC721Transfer(tt.l); got != tt.erc721 {
+			t.Errorf("%s: IsERC721Transfer = %v", tt.name, got)
+		}
+	}
+}
+
+func TestMergeLogsWithSource(t *testing.T) {
+	t.Parallel()
+	block := libcommon.Hash{0xb}
+	log := func(tx byte, index uint) *Log {
+		return &Log{BlockNumber: 10, BlockHash: block, TxHash: libcommon.Hash{tx}, TxIndex: uint(tx), Index: index}
+	}
+	peerA := Logs{log(1, 0), log(2, 2), log(2, 3)}
+	peerB := Logs{log(3, 4), log(1, 0), log(2, 3), log(1, 1)}
+
+	got := MergeLogsWithSource(map[string]Logs{"peerB": peerB, "peerA": peerA})
+	want := []SourcedLog{
+		{Log: peerA[0], Source: "peerA", Sources: []string{"peerA", "peerB"}},
+		{Log: peerB[3], Source: "peerB", Sources: []string{"peerB"}},
+		{Log: peerA[1], Source: "peerA", Sources: []string{"peerA"}},
+		{Log: peerA[2], Source: "peerA", Sources: []string{"peerA", "peerB"}},
+		{Log: peerB[0], Source: "peerB", Sources: []string{"peerB"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d logs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Log != want[i].Log || got[i].Source != want[i].Source || !slices.Equal(got[i].Sources, want[i].Sources) {
+			t.Errorf("log %d: got %v from %v, want %v from %v", i, got[i].Log, got[i].Sources, want[i].Log, want[i].Sources)
+		}
+	}
+	if got := MergeLogsWithSource(nil); len(got) != 0 {
+		t.Errorf("no sources: got %v", got)
+	}
+}
+
+func TestLogsTable(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{
+			BlockNumber: 1881284,
+			TxIndex:     3,
+			Index:       12,
+			Address:     libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490"),
+			Topics:      []libcommon.Hash{TransferSig, {1}, {2}},
+			Data:        make([]byte, 32),
+		},
+		{BlockNumber: 7, Address: libcommon.Address{1}, Removed: true},
+	}
+	got := strings.Split(logs.Table(), "\n")
+	want := []string{
+		"BLOCK    TX  LOG  ADDRESS          SIGNATURE      DATA  REMOVED",
+		"1881284  3   12   0x304a55...3490  0xddf252ad...  32    false",
+		"7        0   0    0x010000...0000  -              0     true",
+		"",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLogsUniqueByDataHash(t *testing.T) {
+	t.Parallel()
+	spam := []byte("claim your reward")
+	logs := Logs{
+		{Index: 0, Address: libcommon.Address{1}, Data: spam},
+		{Index: 1, Address: libcommon.Address{2}, Data: []byte("unique")},
+		{Index: 2, Address: libcommon.Address{3}, Data: slices.Clone(spam)},
+		{Index: 3, Address: libcommon.Address{4}},
+		{Index: 4, Address: libcommon.Address{5}, Data: []byte{}}, // same content as nil data
+		{Index: 5, Address: libcommon.Address{1}, Data: spam},
+	}
+	if got, want := testFLExtractIndex(logs.UniqueByDataHash()), []uint{0, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := (&Log{}).DataHash(), crypto.Keccak256Hash(nil); got != want {
+		t.Errorf("empty data hash: got %x, want %x", got, want)
+	}
+}
+
+func TestConcurrentLogs(t *testing.T) {
+	t.Parallel()
+	const (
+		workers   = 16
+		perWorker = 200
+	)
+	var c ConcurrentLogs
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i += 2 {
+				if i%4 == 0 {
+					c.Append(&Log{TxIndex: uint(w), Index: uint(i)})
+					c.Append(&Log{TxIndex: uint(w), Index: uint(i + 1)})
+					continue
+				}
+				c.AppendBatch(Logs{{TxIndex: uint(w), Index: uint(i)}, {TxIndex: uint(w), Index: uint(i + 1)}})
+			}
+		}(w)
+	}
+	// drain while workers are appending; every log must end up in exactly one drain
+	var drained Logs
+	for i := 0; i < 10; i++ {
+		drained = append(drained, c.Drain()...)
+	}
+	wg.Wait()
+	drained = append(drained, c.Drain()...)
+
+	if len(drained) != workers*perWorker {
+		t.Fatalf("got %d logs, want %d", len(drained), workers*perWorker)
+	}
+	seen := map[[2]uint]struct{}{}
+	for _, l := range drained {
+		seen[[2]uint{l.TxIndex, l.Index}] = struct{}{}
+	}
+	if len(seen) != workers*perWorker {
+		t.Fatalf("got %d distinct logs, want %d", len(seen), workers*perWorker)
+	}
+	if rest := c.Drain(); rest != nil {
+		t.Fatalf("drained collection not empty: %v", rest)
+	}
+}
+
+func TestLogsFromReceiptRLP(t *testing.T) {
+	t.Parallel()
+	// consensus encoding of the receipt of mainnet transaction
+	// 0xa3ece39ae137617669c6933b7578b94e705e765683f260fcfe30eaa41932610f in block
+	// 5417333: status 1, cumulative gas used 0x797db0, its logsBloom and two logs
+	legacy := libcommon.FromHex(
+		"f902240183797db0b901000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000800000000000000000" +
+			"00000000000000000000000000000000000000000000080000000000000000000000000000000000000000000000000200000000000000000008000000000000" +
+			"00004010000010100000000000000000000000000000000000000000000000000040000080000000000000080000000000000000000000000000000000000000" +
+			"00002000000000000000000000000200000000000000000000000000000000000000000000000000002000000001000000000000000000000000000000000000" +
+			"0000000000000000000000f90119f87a94d6df5935cd03a768b7b9e92637a01b25e24cb709f842a08940c4b8e215f8822c5c8f0056c12652c746cbc57eedbd2a" +
+			"440b175971d47a77a0000000000000000000000000d907941c8b3b966546fc408b8c942eb10a4f98dfa000000000000000000000000000000000000000000000" +
+			"00000000008bb2c97000f89b94d6df5935cd03a768b7b9e92637a01b25e24cb709f863a0ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4d" +
+			"f523b3efa00000000000000000000000000000000000000000000000000000000000000000a0000000000000000000000000d907941c8b3b966546fc408b8c94" +
+			"2eb10a4f98dfa00000000000000000000000000000000000000000000000000000008bb2c97000")
+	logs, bloom := mainnetReceiptLogs()
+
+	typed, err := (&Receipt{Type: DynamicFeeTxType, Status: ReceiptStatusSuccessful, CumulativeGasUsed: 0x797db0, Bloom: bloom, Logs: logs}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, enc := range map[string][]byte{"mainnet legacy": legacy, "typed": typed} {
+		got, err := LogsFromReceiptRLP(enc)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(logs) {
+			t.Fatalf("%s: got %d logs, want %d", name, len(got), len(logs))
+		}
+		for i := range logs {
+			if got[i].Address != logs[i].Address || !slices.Equal(got[i].Topics, logs[i].Topics) || !bytes.Equal(got[i].Data, logs[i].Data) {
+				t.Errorf("%s log %d: got %v, want %v", name, i, got[i], logs[i])
+			}
+			if got[i].BlockNumber != 0 || got[i].TxHash != (libcommon.Hash{}) || got[i].Index != 0 {
+				t.Errorf("%s log %d: derived fields set: %v", name, i, got[i])
+			}
+		}
+
+		if _, err := LogsFromReceiptRLP(enc[:len(enc)-1]); err == nil {
+			t.Errorf("%s: truncated receipt decoded", name)
+		}
+		if _, err := LogsFromReceiptRLP(append(slices.Clone(enc), 0x80)); !errors.Is(err, rlp.ErrMoreThanOneValue) {
+			t.Errorf("%s: trailing data: got %v", name, err)
+		}
+	}
+
+	noLogs, _ := (&Receipt{Status: ReceiptStatusFailed}).MarshalBinary()
+	if got, err := LogsFromReceiptRLP(noLogs); err != nil || len(got) != 0 {
+		t.Errorf("receipt without logs: got %v, %v", got, err)
+	}
+	if _, err := LogsFromReceiptRLP([]byte{DynamicFeeTxType}); !errors.Is(err, errShortTypedReceipt) {
+		t.Errorf("bare type byte: got %v", err)
+	}
+}
+
+func TestLogsStorageSize(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{},
+		{Data: []byte{0x7f}}, // single byte encoded as itself
+		{Data: []byte{0x80}}, // single byte needing a prefix
+		{Topics: []libcommon.Hash{{1}}, Data: make([]byte, 55)}, // long list, short string
+		{Topics: make([]libcommon.Hash, 4), Data: make([]byte, 56)},
+		{Topics: make([]libcommon.Hash, 2), Data: make([]byte, 70_000)},
+	}
+	var want int
+	for i, l := range logs {
+		enc, err := rlp.EncodeToBytes((*LogForStorage)(l))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := logs[i : i+1].StorageSize(); got != len(enc) {
+			t.Errorf("log %d: got %d bytes, encoding has %d", i, got, len(enc))
+		}
+		want += len(enc)
+	}
+	if got := logs.StorageSize(); got != want {
+		t.Errorf("got %d bytes, want %d", got, want)
+	}
+	if got := Logs(nil).StorageSize(); got != 0 {
+		t.Errorf("no logs: got %d bytes", got)
+	}
+}
+
+func TestErigonLogsFilterByTimeRange(t *testing.T) {
+	t.Parallel()
+	logs := ErigonLogs{
+		{Index: 0, Timestamp: 999},
+		{Index: 1, Timestamp: 1000},
+		{Index: 2, Timestamp: 1500},
+		{Index: 3, Timestamp: 2000},
+		{Index: 4, Timestamp: 2001},
+		{Index: 5}, // timestamp not populated
+	}
+	indices := func(logs ErigonLogs) []uint {
+		var o []uint
+		for _, l := range logs {
+			o = append(o, l.Index)
+		}
+		return o
+	}
+	tests := []struct {
+		from, to uint64
+		want     []uint
+	}{
+		{1000, 2000, []uint{1, 2, 3}},
+		{1500, 1500, []uint{2}},
+		{0, 1000, []uint{0, 1}}, // zero timestamps are excluded even when 0 is in range
+		{0, ^uint64(0), []uint{0, 1, 2, 3, 4}},
+		{2000, 1000, nil},
+	}
+	for _, tt := range tests {
+		if got := indices(logs.FilterByTimeRange(tt.from, tt.to)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("[%d, %d]: got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestLogMatchPositions(t *testing.T) {
+	t.Parallel()
+	var (
+		a libcommon.Hash = [32]byte{1}
+		b libcommon.Hash = [32]byte{2}
+		c libcommon.Hash = [32]byte{3}
+	)
+	l := &Log{Topics: []libcommon.Hash{a, b, c}}
+	tests := map[string]struct {
+		topics [][]libcommon.Hash
+		want   []int
+	}{
+		"all match":            {[][]libcommon.Hash{{a}, {b}, {c}}, []int{0, 1, 2}},
+		"wildcard excluded":    {[][]libcommon.Hash{{a}, {}, {c}}, []int{0, 2}},
+		"partial":              {[][]libcommon.Hash{{b}, {a, b}, {a}}, []int{1}},
+		"past the last topic":  {[][]libcommon.Hash{{}, {}, {}, {c}}, nil},
+		"no filter":            {nil, nil},
+		"alternatives at last": {[][]libcommon.Hash{nil, nil, {a, c}}, []int{2}},
+	}
+	for name, tt := range tests {
+		if got := l.MatchPositions(tt.topics); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestLogToRow(t *testing.T) {
+	t.Parallel()
+	l := &Log{
+		Address:     libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490"),
+		Topics:      []libcommon.Hash{TransferSig, {1}},
+		Data:        []byte{0xab, 0xcd},
+		BlockNumber: 1881284,
+		TxHash:      libcommon.Hash{2},
+		TxIndex:     3,
+		Index:       4,
+		Removed:     true,
+	}
+	want := map[string]any{
+		"address":      "0x304a554a310c7e546dfe434669c62820b7d83490",
+		"topic0":       "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+		"topic1":       "0x0100000000000000000000000000000000000000000000000000000000000000",
+		"topic2":       nil,
+		"topic3":       nil,
+		"data":         "0xabcd",
+		"block_number": uint64(1881284),
+		"tx_hash":      "0x0200000000000000000000000000000000000000000000000000000000000000",
+		"tx_index":     uint(3),
+		"log_index":    uint(4),
+		"removed":      true,
+	}
+	if got := l.ToRow(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	rows := Logs{l, {}}.ToRows()
+	if len(rows) != 2 || !reflect.DeepEqual(rows[0], want) {
+		t.Fatalf("got rows %v", rows)
+	}
+	// every row has the same columns
+	for key := range want {
+		if _, ok := rows[1][key]; !ok {
+			t.Errorf("empty log row misses %s", key)
+		}
+	}
+	if len(rows[1]) != len(want) || rows[1]["topic0"] != nil || rows[1]["data"] != "0x" {
+		t.Errorf("empty log row: %v", rows[1])
+	}
+}
+
+func TestAssembleFromTxContextsOverflow(t *testing.T) {
+	t.Parallel()
+	ctxs := map[uint]TxLogContext{
+		0: {LogIndexBase: 0},
+		1: {LogIndexBase: MaxLogIndex - 1},
+	}
+	// the two logs of tx 1 take the last two indices representable on 32-bit platforms
+	logs := Logs{{TxIndex: 0}, {TxIndex: 1}, {TxIndex: 1}}
+	if err := logs.AssembleFromTxContexts(1, libcommon.Hash{}, ctxs); err != nil {
+		t.Fatal(err)
+	}
+	if logs[2].Index != MaxLogIndex {
+		t.Fatalf("last index: got %d, want %d", logs[2].Index, uint64(MaxLogIndex))
+	}
+
+	// a third log would wrap around a 32-bit uint
+	logs = Logs{{TxIndex: 1}, {TxIndex: 1}, {TxIndex: 1}}
+	if err := logs.AssembleFromTxContexts(1, libcommon.Hash{1}, ctxs); !errors.Is(err, ErrLogIndexOverflow) {
+		t.Fatalf("got %v, want ErrLogIndexOverflow", err)
+	}
+	for i, l := range logs {
+		if l.BlockHash != (libcommon.Hash{}) || l.Index != 0 {
+			t.Errorf("log %d modified on error: %v", i, l)
+		}
+	}
+}
+
+func TestLogsDeltaTo(t *testing.T) {
+	t.Parallel()
+	var (
+		block1  = libcommon.Hash{1}
+		block2  = libcommon.Hash{2}
+		block2b = libcommon.Hash{0x2b} // replaces block2 after the reorg
+		block3  = libcommon.Hash{3}
+		tx1     = libcommon.Hash{0x11}
+		tx2     = libcommon.Hash{0x12}
+	)
+	log := func(block, tx libcommon.Hash, index uint) *Log {
+		return &Log{BlockHash: block, TxHash: tx, Index: index}
+	}
+
+	// first poll sees blocks 1 and 2, the next one also block 3
+	poll1 := Logs{log(block1, tx1, 0), log(block2, tx1, 0), log(block2, tx2, 1)}
+	poll2 := Logs{log(block1, tx1, 0), log(block2, tx1, 0), log(block2, tx2, 1), log(block3, tx1, 0), log(block3, tx2, 1)}
+	added, removed := poll1.DeltaTo(poll2)
+	if !reflect.DeepEqual(added, poll2[3:]) || len(removed) != 0 {
+		t.Fatalf("new block: got added %v removed %v, want added %v and nothing removed", added, removed, poll2[3:])
+	}
+
+	// block 2 is reorged out: tx1 is re-included in block2b, tx2 is dropped
+	poll3 := Logs{log(block1, tx1, 0), log(block2b, tx1, 0), log(block3, tx1, 0), log(block3, tx2, 1)}
+	added, removed = poll2.DeltaTo(poll3)
+	if !reflect.DeepEqual(added, Logs{poll3[1]}) {
+		t.Errorf("reorg: got added %v, want %v", added, Logs{poll3[1]})
+	}
+	if !reflect.DeepEqual(removed, Logs{poll2[1], poll2[2]}) {
+		t.Errorf("reorg: got removed %v, want %v", removed, Logs{poll2[1], poll2[2]})
+	}
+
+	if added, removed := poll3.DeltaTo(poll3); added != nil || removed != nil {
+		t.Errorf("same snapshot: got added %v removed %v, want nothing", added, removed)
+	}
+}
+
+func TestLogsRedactTopicsAfter(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Topics: []libcommon.Hash{TransferSig, {1}, {2}}, Data: []byte{3}},
+		{Topics: []libcommon.Hash{{4}}},
+		{},
+	}
+	got := logs.RedactTopicsAfter(0)
+	want := [][]libcommon.Hash{{TransferSig, {}, {}}, {{4}}, nil}
+	for i := range want {
+		if !reflect.DeepEqual(got[i].Topics, want[i]) {
+			t.Errorf("log %d: got topics %v, want %v", i, got[i].Topics, want[i])
+		}
+	}
+	if !bytes.Equal(got[0].Data, logs[0].Data) {
+		t.Errorf("data changed: got %x, want %x", got[0].Data, logs[0].Data)
+	}
+	if logs[0].Topics[1] != (libcommon.Hash{1}) {
+		t.Error("original log was modified")
+	}
+	if got := logs.RedactTopicsAfter(1); got[0].Topics[1] != (libcommon.Hash{1}) || got[0].Topics[2] != (libcommon.Hash{}) {
+		t.Errorf("position 1: got topics %v", got[0].Topics)
+	}
+}
+
+func TestNextLogIndex(t *testing.T) {
+	t.Parallel()
+	if got := NextLogIndex(nil); got != 0 {
+		t.Fatalf("empty: got %d, want 0", got)
+	}
+	// three transactions emitting 2, 0 and 3 logs
+	var block Logs
+	for _, n := range []int{2, 0, 3} {
+		base := NextLogIndex(block)
+		for i := 0; i < n; i++ {
+			block = append(block, &Log{Index: base + uint(i)})
+		}
+	}
+	for i, l := range block {
+		if l.Index != uint(i) {
+			t.Errorf("log %d: got index %d", i, l.Index)
+		}
+	}
+	if got := NextLogIndex(block); got != 5 {
+		t.Errorf("got %d, want 5", got)
+	}
+	if got := NextLogIndex(Logs{{Index: 4}, {Index: 1}}); got != 5 {
+		t.Errorf("unordered: got %d, want 5", got)
+	}
+}
+
+func TestLogsChunk(t *testing.T) {
+	t.Parallel()
+	logs := make(Logs, 7)
+	for i := range logs {
+		logs[i] = &Log{Index: uint(i)}
+	}
+	sizes := func(chunks []Logs) (o []int) {
+		for _, c := range chunks {
+			o = append(o, len(c))
+		}
+		return o
+	}
+	for _, tt := range []struct {
+		size int
+		want []int
+	}{
+		{size: 1, want: []int{1, 1, 1, 1, 1, 1, 1}},
+		{size: 3, want: []int{3, 3, 1}},
+		{size: 7, want: []int{7}},
+		{size: 10, want: []int{7}},
+		{size: 0, want: []int{7}},
+		{size: -1, want: []int{7}},
+	} {
+		if got := sizes(logs.Chunk(tt.size)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("size %d: got chunk sizes %v, want %v", tt.size, got, tt.want)
+		}
+	}
+	if got := sizes(logs[:6].Chunk(2)); !reflect.DeepEqual(got, []int{2, 2, 2}) {
+		t.Errorf("exact multiple: got chunk sizes %v", got)
+	}
+	for _, empty := range []Logs{nil, {}} {
+		for _, size := range []int{0, 1, 3} {
+			if got := empty.Chunk(size); len(got) != 0 {
+				t.Errorf("empty logs, size %d: got %d chunks, want none", size, len(got))
+			}
+		}
+	}
+
+	chunks := logs.Chunk(3)
+	if &chunks[1][0] != &logs[3] {
+		t.Error("chunks do not alias the original slice")
+	}
+	_ = append(chunks[0], &Log{})
+	if logs[3].Index != 3 {
+		t.Error("appending to a chunk overwrote the next one")
+	}
+}
+
+func TestLogsFilterAnonymousByTopics(t *testing.T) {
+	t.Parallel()
+	var a, b, c = libcommon.Hash{0xa}, libcommon.Hash{0xb}, libcommon.Hash{0xc}
+	logs := Logs{
+		{Index: 0, Topics: []libcommon.Hash{a, b, a}},
+		{Index: 1, Topics: []libcommon.Hash{b, a}},
+		{Index: 2, Topics: []libcommon.Hash{c, a, a, a}},
+		{Index: 3},
+	}
+	if got, want := logs[0].TopicsMultiset(), map[libcommon.Hash]int{a: 2, b: 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("multiset: got %v, want %v", got, want)
+	}
+	for _, tt := range []struct {
+		name     string
+		required map[libcommon.Hash]int
+		want     []uint
+	}{
+		{"any order", map[libcommon.Hash]int{a: 1, b: 1}, []uint{0, 1}},
+		{"repeated", map[libcommon.Hash]int{a: 2}, []uint{0, 2}},
+		{"more than present", map[libcommon.Hash]int{a: 3, c: 1}, []uint{2}},
+		{"repeated and other", map[libcommon.Hash]int{a: 2, b: 1}, []uint{0}},
+		{"nothing required", nil, []uint{0, 1, 2, 3}},
+	} {
+		if got := testFLExtractIndex(logs.FilterAnonymousByTopics(tt.required)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRebuildCanonicalOrder(t *testing.T) {
+	t.Parallel()
+	var (
+		tx0, tx1, tx2 = libcommon.Hash{0x10}, libcommon.Hash{0x11}, libcommon.Hash{0x12}
+		unknown       = libcommon.Hash{0xff}
+	)
+	txOrder := map[libcommon.Hash]uint{tx0: 0, tx1: 1, tx2: 2}
+	canonical := Logs{
+		{TxHash: tx0, Index: 0},
+		{TxHash: tx0, Index: 1},
+		{TxHash: tx1, Index: 2},
+		{TxHash: tx2, Index: 3},
+		{TxHash: tx2, Index: 4},
+		{TxHash: tx2, Index: 5},
+	}
+	shuffled := Logs{canonical[4], canonical[2], canonical[0], canonical[5], canonical[1], canonical[3]}
+	got := RebuildCanonicalOrder(shuffled, txOrder)
+	if !reflect.DeepEqual(got, canonical) {
+		t.Fatalf("got %v, want %v", testFLExtractIndex(got), testFLExtractIndex(canonical))
+	}
+	if shuffled[0] != canonical[4] {
+		t.Error("input was reordered")
+	}
+
+	stray1, stray2 := &Log{TxHash: unknown, Index: 9}, &Log{TxHash: unknown, Index: 8}
+	got = RebuildCanonicalOrder(Logs{stray1, canonical[3], stray2, canonical[0]}, txOrder)
+	if want := (Logs{canonical[0], canonical[3], stray1, stray2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("unknown tx: got %v, want %v", testFLExtractIndex(got), testFLExtractIndex(want))
+	}
+}
+
+func TestLogsRepairIndices(t *testing.T) {
+	t.Parallel()
+	var block1, block2 = libcommon.Hash{1}, libcommon.Hash{2}
+	logs := Logs{
+		{BlockNumber: 1, BlockHash: block1, TxIndex: 0, Index: 0},
+		{BlockNumber: 1, BlockHash: block1, TxIndex: 0, Index: 1},
+		{BlockNumber: 1, BlockHash: block1, TxIndex: 1, Index: 1}, // duplicate
+		{BlockNumber: 1, BlockHash: block1, TxIndex: 2, Index: 4}, // gap
+		{BlockNumber: 2, BlockHash: block2, TxIndex: 0, Index: 1}, // same index, other block
+		{BlockNumber: 2, BlockHash: block2, TxIndex: 1, Index: 1}, // duplicate
+		{BlockNumber: 1, BlockHash: block1, TxIndex: 3, Index: 1}, // duplicate
+	}
+	if got, want := logs.FindDuplicateIndices(), [][2]int{{1, 2}, {4, 5}, {1, 6}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("duplicates: got %v, want %v", got, want)
+	}
+
+	repaired := logs.RepairIndices()
+	type pos struct{ block, tx, index uint64 }
+	var got []pos
+	for _, l := range repaired {
+		got = append(got, pos{l.BlockNumber, uint64(l.TxIndex), uint64(l.Index)})
+	}
+	want := []pos{{1, 0, 0}, {1, 0, 1}, {1, 1, 2}, {1, 2, 3}, {1, 3, 4}, {2, 0, 0}, {2, 1, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("repaired: got %v, want %v", got, want)
+	}
+	if dups := repaired.FindDuplicateIndices(); len(dups) != 0 {
+		t.Errorf("repaired logs still have duplicates %v", dups)
+	}
+	if logs[2].Index != 1 {
+		t.Error("original logs were modified")
+	}
+}
+
+func TestLogsWriteCSV(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{
+			Address:     libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490"),
+			Topics:      []libcommon.Hash{TransferSig, {1}, {2}},
+			Data:        []byte{0xde, 0xad},
+			BlockNumber: 1881284,
+			TxHash:      libcommon.Hash{0xaa},
+			TxIndex:     3,
+			Index:       7,
+		},
+		{BlockNumber: 1881285, Removed: true},
+	}
+	var buf bytes.Buffer
+	if err := logs.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(logs)+1 {
+		t.Fatalf("got %d rows, want %d", len(rows), len(logs)+1)
+	}
+	if want := []string{"block_number", "tx_hash", "tx_index", "log_index", "address", "topic0", "topic1", "topic2", "topic3", "data", "removed"}; !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("header: got %v, want %v", rows[0], want)
+	}
+	want := []string{
+		"1881284",
+		"0xaa00000000000000000000000000000000000000000000000000000000000000",
+		"3",
+		"7",
+		"0x304a554a310c7e546dfe434669c62820b7d83490",
+		"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+		"0x0100000000000000000000000000000000000000000000000000000000000000",
+		"0x0200000000000000000000000000000000000000000000000000000000000000",
+		"",
+		"0xdead",
+		"false",
+	}
+	if !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("row 1: got %v, want %v", rows[1], want)
+	}
+	if got := rows[2]; got[5] != "" || got[9] != "0x" || got[10] != "true" {
+		t.Errorf("row 2: got %v", got)
+	}
+}
+
+func TestERC1155Transfers(t *testing.T) {
+	t.Parallel()
+	// Logs of mainnet block 0x14ee094309fbe8f70b65f45ebcc08fb33f126942d97464aad5eb91cfd1e2d269,
+	// as stored in go-ethereum's core/rawdb/testdata/stored_receipts.bin.
+	// Log 310, emitted by transaction 253: a transfer of one unit on the OpenSea
+	// Shared Storefront.
+	single := &Log{
+		Address: libcommon.HexToAddress("0x495f947276749ce646f68ac8c248420045cb7b5e"),
+		Topics: []libcommon.Hash{
+			libcommon.HexToHash("0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"),
+			libcommon.HexToHash("0x000000000000000000000000a2efa94766ee867c91f71e8020a3c97ae687e5c3"),
+			libcommon.HexToHash("0x000000000000000000000000a2efa94766ee867c91f71e8020a3c97ae687e5c3"),
+			libcommon.HexToHash("0x0000000000000000000000005a70357eb343f6f8bab915ee7e3cd518204bb476"),
+		},
+		Data: libcommon.FromHex("0x" +
+			"951dcee1737350b1af08da35577146b52025319e000000000000090000000001" +
+			"0000000000000000000000000000000000000000000000000000000000000001"),
+	}
+	// Log 355, emitted by transaction 265: ids [10, 11, 12] with values [128, 32, 16].
+	batch := &Log{
+		Address: libcommon.HexToAddress("0x046427fa6b924739cd98ee507cb0db34c7a66c2e"),
+		Topics: []libcommon.Hash{
+			libcommon.HexToHash("0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"),
+			libcommon.HexToHash("0x0000000000000000000000003fe7493385eaae7ec98dc33f77b4fbe71aeb5edd"),
+			libcommon.HexToHash("0x0000000000000000000000003fe7493385eaae7ec98dc33f77b4fbe71aeb5edd"),
+			libcommon.HexToHash("0x0000000000000000000000005b364b290399c7f4274304d06d31b7a891873675"),
+		},
+		Data: libcommon.FromHex("0x" +
+			"0000000000000000000000000000000000000000000000000000000000000040" +
+			"00000000000000000000000000000000000000000000000000000000000000c0" +
+			"0000000000000000000000000000000000000000000000000000000000000003" +
+			"000000000000000000000000000000000000000000000000000000000000000a" +
+			"000000000000000000000000000000000000000000000000000000000000000b" +
+			"000000000000000000000000000000000000000000000000000000000000000c" +
+			"0000000000000000000000000000000000000000000000000000000000000003" +
+			"0000000000000000000000000000000000000000000000000000000000000080" +
+			"0000000000000000000000000000000000000000000000000000000000000020" +
+			"0000000000000000000000000000000000000000000000000000000000000010"),
+	}
+	// Log 356, the next one of transaction 265: a mint of 128 units of token id 10.
+	mint := &Log{
+		Address: libcommon.HexToAddress("0xc67ded0ec78b849e17771b2e8a7e303b4dad6dd4"),
+		Topics: []libcommon.Hash{
+			libcommon.HexToHash("0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"),
+			libcommon.HexToHash("0x0000000000000000000000005b364b290399c7f4274304d06d31b7a891873675"),
+			libcommon.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000000"),
+			libcommon.HexToHash("0x0000000000000000000000003fe7493385eaae7ec98dc33f77b4fbe71aeb5edd"),
+		},
+		Data: libcommon.FromHex("0x" +
+			"000000000000000000000000000000000000000000000000000000000000000a" +
+			"0000000000000000000000000000000000000000000000000000000000000080"),
+	}
+	// a batch of empty arrays
+	emptyBatch := &Log{Topics: batch.Topics, Data: libcommon.FromHex("0x" +
+		"0000000000000000000000000000000000000000000000000000000000000040" +
+		"0000000000000000000000000000000000000000000000000000000000000060" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000")}
+	for _, tt := range []struct {
+		name          string
+		l             *Log
+		single, batch bool
+	}{
+		{"mainnet transfer single", single, true, false},
+		{"mainnet mint", mint, true, false},
+		{"mainnet transfer batch", batch, false, true},
+		{"empty batch", emptyBatch, false, true},
+		{"single with three topics", &Log{Topics: single.Topics[:3], Data: single.Data}, false, false},
+		{"batch with short data", &Log{Topics: batch.Topics, Data: batch.Data[:96]}, false, false},
+		{"erc721 transfer", &Log{Topics: []libcommon.Hash{TransferSig, {1}, {2}, {3}}}, false, false},
+		{"anonymous", &Log{}, false, false},
+	} {
+		if got := IsERC1155TransferSingle(tt.l); got != tt.single {
+			t.Errorf("%s: IsERC1155TransferSingle = %v", tt.name, got)
+		}
+		if got := IsERC1155TransferBatch(tt.l); got != tt.batch {
+			t.Errorf("%s: IsERC1155TransferBatch = %v", tt.name, got)
+		}
+	}
+}
+
+func TestReorgLogDelta(t *testing.T) {
+	t.Parallel()
+	var (
+		token  = libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490")
+		alice  = AddressToTopic(libcommon.Address{0xa})
+		bob    = AddressToTopic(libcommon.Address{0xb})
+		carol  = AddressToTopic(libcommon.Address{0xc})
+		amount = make([]byte, 32)
+	)
+	transfer := func(from, to libcommon.Hash, block uint64, blockHash libcommon.Hash, index uint) *Log {
+		return &Log{Address: token, Topics: []libcommon.Hash{TransferSig, from, to}, Data: amount, BlockNumber: block, BlockHash: blockHash, Index: index}
+	}
+	// blocks 10 and 11 are replaced by 10' and 11'. The alice->bob transfers are
+	// re-included at other positions, one bob->carol transfer is dropped and an
+	// alice->carol transfer is new.
+	var b10, b11, b10r, b11r = libcommon.Hash{0x10}, libcommon.Hash{0x11}, libcommon.Hash{0x1a}, libcommon.Hash{0x1b}
+	oldLogs := Logs{
+		transfer(alice, bob, 10, b10, 0),
+		transfer(bob, carol, 10, b10, 1),
+		transfer(alice, bob, 11, b11, 0),
+		transfer(bob, carol, 11, b11, 1),
+	}
+	newLogs := Logs{
+		transfer(alice, bob, 10, b10r, 0),
+		transfer(alice, carol, 10, b10r, 1),
+		transfer(bob, carol, 11, b11r, 0),
+		transfer(alice, bob, 11, b11r, 1),
+	}
+	reverted, applied := ReorgLogDelta(oldLogs, newLogs)
+	if len(reverted) != 1 || reverted[0].Topics[1] != bob || reverted[0].Topics[2] != carol || !reverted[0].Removed {
+		t.Errorf("got reverted %v, want one removed bob->carol transfer", reverted)
+	}
+	if oldLogs[1].Removed || oldLogs[3].Removed {
+		t.Error("old logs were modified")
+	}
+	if !reflect.DeepEqual(applied, Logs{newLogs[1]}) {
+		t.Errorf("got applied %v, want %v", applied, Logs{newLogs[1]})
+	}
+
+	if reverted, applied := ReorgLogDelta(oldLogs, oldLogs); reverted != nil || applied != nil {
+		t.Errorf("no reorg: got reverted %v applied %v", reverted, applied)
+	}
+
+	// logs with more than four topics are not valid, but must not crash the delta
+	wide := &Log{Topics: []libcommon.Hash{alice, bob, carol, alice, bob}}
+	reverted, applied = ReorgLogDelta(Logs{wide}, Logs{wide, wide})
+	if len(reverted) != 0 || !reflect.DeepEqual(applied, Logs{wide}) {
+		t.Errorf("five topics: got reverted %v applied %v", reverted, applied)
+	}
+}
+
+func TestLogsApproxUniqueAddresses(t *testing.T) {
+	t.Parallel()
+	if got := (Logs{}).ApproxUniqueAddresses(); got != 0 {
+		t.Fatalf("empty: got %d", got)
+	}
+	addr := func(i int) libcommon.Address {
+		var a libcommon.Address
+		binary.BigEndian.PutUint32(a[16:], uint32(i))
+		return a
+	}
+	for _, n := range []int{1, 10, 100, 1_000, 20_000, 100_000} {
+		logs := make(Logs, 0, 2*n)
+		for i := 0; i < n; i++ {
+			// every address emits twice
+			logs = append(logs, &Log{Address: addr(i)}, &Log{Address: addr(i)})
+		}
+		got := logs.ApproxUniqueAddresses()
+		if diff := math.Abs(float64(got)-float64(n)) / float64(n); diff > 0.1 {
+			t.Errorf("%d addresses: estimate %d is off by %.1f%%", n, got, 100*diff)
+		}
+	}
+}
+
+func TestLogsSplitByRemoved(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Index: 0},
+		{Index: 1, Removed: true},
+		{Index: 2},
+		{Index: 3, Removed: true},
+		{Index: 4, Removed: true},
+		{Index: 5},
+	}
+	canonical, removed := logs.SplitByRemoved()
+	if got := testFLExtractIndex(canonical); !reflect.DeepEqual(got, []uint{0, 2, 5}) {
+		t.Errorf("canonical: got %v", got)
+	}
+	if got := testFLExtractIndex(removed); !reflect.DeepEqual(got, []uint{1, 3, 4}) {
+		t.Errorf("removed: got %v", got)
+	}
+	if canonical, removed := (Logs{}).SplitByRemoved(); canonical != nil || removed != nil {
+		t.Errorf("empty: got %v, %v", canonical, removed)
+	}
+}
+
+func TestLogsMarshalJSONAnnotated(t *testing.T) {
+	t.Parallel()
+	var r SignatureRegistry
+	r.Register(TransferSig, "Transfer(address,address,uint256)")
+	r.Register(ApprovalSig, `Approval "quoted"`)
+	logs := Logs{
+		{Address: libcommon.Address{1}, Topics: []libcommon.Hash{TransferSig, {2}}, Data: []byte{3}, BlockNumber: 4, Index: 5},
+		{Topics: []libcommon.Hash{{9}}},
+		{},
+		{Topics: []libcommon.Hash{ApprovalSig}},
+	}
+	enc, err := logs.MarshalJSONAnnotated(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(enc, &got); err != nil {
+		t.Fatalf("invalid json %s: %v", enc, err)
+	}
+	wantNames := []any{"Transfer(address,address,uint256)", nil, nil, `Approval "quoted"`}
+	for i, l := range logs {
+		name, ok := got[i]["eventName"]
+		if name != wantNames[i] || ok != (wantNames[i] != nil) {
+			t.Errorf("log %d: got eventName %v (present %v), want %v", i, name, ok, wantNames[i])
+		}
+		delete(got[i], "eventName")
+		plain, err := json.Marshal(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want map[string]any
+		if err := json.Unmarshal(plain, &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got[i], want) {
+			t.Errorf("log %d: got %v, want %v", i, got[i], want)
+		}
+	}
+
+	// without a registry no names are known and logs encode as usual
+	enc, err = logs.MarshalJSONAnnotated(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := json.Marshal(logs); !bytes.Equal(enc, plain) {
+		t.Errorf("nil registry: got %s, want %s", enc, plain)
+	}
+}
+
+func TestLogCompressedEncoding(t *testing.T) {
+	t.Parallel()
+	// Transfer of 0x304a554a310c7e546dfe434669c62820b7d83490 in mainnet block 1881284,
+	// as in the call_tracer_withLog/multi_contracts tracer test.
+	transfer := &Log{
+		Address: libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490"),
+		Topics: []libcommon.Hash{
+			TransferSig,
+			libcommon.HexToHash("0x000000000000000000000000c0ee9db1a9e07ca63e4ff0d5fb6f86bf68d47b89"),
+			libcommon.HexToHash("0x0000000000000000000000004fd27b205895e698fa350f7ea57cec8a21927fcd"),
+		},
+		Data: libcommon.FromHex("0x00000000000000000000000000000000000000000001819451f999d617dafa93"),
+	}
+	for _, l := range []*Log{transfer, {}, {Topics: []libcommon.Hash{{}, {}, {}, {}}, Data: make([]byte, 1024)}} {
+		enc, err := l.EncodeCompressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeCompressedLog(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Address != l.Address || !slices.Equal(got.Topics, l.Topics) || !bytes.Equal(got.Data, l.Data) {
+			t.Errorf("round trip: got %v, want %v", got, l)
+		}
+	}
+
+	raw, err := RLPLogCodec{}.Encode(transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := transfer.EncodeCompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(raw) {
+		t.Errorf("compressed transfer is %d bytes, not smaller than the %d bytes of its rlp", len(compressed), len(raw))
+	}
+
+	if _, err := DecodeCompressedLog([]byte{0xff, 0xff, 0xff}); err == nil || !strings.Contains(err.Error(), "invalid snappy log") {
+		t.Errorf("garbage: got error %v", err)
+	}
+}
+
+func TestLogContentHash(t *testing.T) {
+	t.Parallel()
+	l := &Log{
+		Address:     libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490"),
+		Topics:      []libcommon.Hash{TransferSig, {1}, {2}},
+		Data:        []byte{3},
+		BlockNumber: 1,
+		BlockHash:   libcommon.Hash{0xb1},
+		TxHash:      libcommon.Hash{0x11},
+		TxIndex:     2,
+		Index:       3,
+	}
+	enc, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.ContentHash(), crypto.Keccak256Hash(enc); got != want {
+		t.Fatalf("got %x, want keccak256 of the log rlp %x", got, want)
+	}
+
+	success := true
+	moved := l.Copy()
+	moved.BlockNumber, moved.BlockHash, moved.TxHash, moved.TxIndex, moved.Index = 9, libcommon.Hash{0xb9}, libcommon.Hash{0x19}, 0, 0
+	moved.Removed, moved.TxSuccess = true, &success
+	if moved.ContentHash() != l.ContentHash() {
+		t.Error("logs differing only in derived fields hash differently")
+	}
+
+	for name, modify := range map[string]func(*Log){
+		"address": func(l *Log) { l.Address[0]++ },
+		"topic":   func(l *Log) { l.Topics[2][0]++ },
+		"topics":  func(l *Log) { l.Topics = l.Topics[:2] },
+		"data":    func(l *Log) { l.Data = append(l.Data, 0) },
+	} {
+		other := l.Copy()
+		modify(other)
+		if other.ContentHash() == l.ContentHash() {
+			t.Errorf("%s: changed log has the same content hash", name)
+		}
+	}
+}
+
+func TestLogsFilterPerAddressSignatures(t *testing.T) {
+	t.Parallel()
+	var token, nft = libcommon.Address{0x20}, libcommon.Address{0x72}
+	logs := Logs{
+		{Index: 0, Address: token, Topics: []libcommon.Hash{TransferSig}},
+		{Index: 1, Address: token, Topics: []libcommon.Hash{ApprovalForAllSig}},
+		{Index: 2, Address: nft, Topics: []libcommon.Hash{TransferSig}},
+		{Index: 3, Address: nft, Topics: []libcommon.Hash{ApprovalForAllSig}},
+		{Index: 4, Address: nft},
+		{Index: 5, Address: libcommon.Address{0xff}, Topics: []libcommon.Hash{TransferSig}},
+		{Index: 6, Address: token, Topics: []libcommon.Hash{{1}, TransferSig}},
+	}
+	allow := map[libcommon.Address]map[libcommon.Hash]struct{}{
+		token: {TransferSig: {}},
+		nft:   {ApprovalForAllSig: {}},
+	}
+	if got := testFLExtractIndex(logs.FilterPerAddressSignatures(allow, 0)); !reflect.DeepEqual(got, []uint{0, 3}) {
+		t.Errorf("got %v, want [0 3]", got)
+	}
+	// the same addresses and signatures as a global filter also match the crossed pairs
+	global := logs.Filter(map[libcommon.Address]struct{}{token: {}, nft: {}}, [][]libcommon.Hash{{TransferSig, ApprovalForAllSig}}, 0)
+	if got := testFLExtractIndex(global); !reflect.DeepEqual(got, []uint{0, 1, 2, 3}) {
+		t.Errorf("global filter: got %v, want [0 1 2 3]", got)
+	}
+	if got := testFLExtractIndex(logs.FilterPerAddressSignatures(allow, 1)); !reflect.DeepEqual(got, []uint{0}) {
+		t.Errorf("maxLogs 1: got %v, want [0]", got)
+	}
+	if got := logs.FilterPerAddressSignatures(nil, 0); len(got) != 0 {
+		t.Errorf("empty allow: got %v", testFLExtractIndex(got))
+	}
+}
+
+func TestLogsReassignTxIndex(t *testing.T) {
+	t.Parallel()
+	var txA, txB, txC, unknown = libcommon.Hash{0xa}, libcommon.Hash{0xb}, libcommon.Hash{0xc}, libcommon.Hash{0xff}
+	// TxIndex as assigned before the transactions were reordered to C, A, B
+	logs := Logs{
+		{TxHash: txA, TxIndex: 0},
+		{TxHash: txA, TxIndex: 0},
+		{TxHash: txB, TxIndex: 1},
+		{TxHash: txC, TxIndex: 2},
+		{TxHash: unknown, TxIndex: 7},
+	}
+	logs.ReassignTxIndex([]libcommon.Hash{txC, txA, txB})
+	var got []uint
+	for _, l := range logs {
+		got = append(got, l.TxIndex)
+	}
+	if want := []uint{1, 1, 2, 0, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tx indices %v, want %v", got, want)
+	}
+}
+
+func TestLogBucket(t *testing.T) {
+	t.Parallel()
+	l := &Log{Address: libcommon.HexToAddress("0x304a554a310c7e546dfe434669c62820b7d83490"), Topics: []libcommon.Hash{TransferSig}}
+	// pinned so that a change of the hash, which would reshuffle dashboards, is noticed
+	if got := l.Bucket(16); got != 11 {
+		t.Errorf("got bucket %d, want 11", got)
+	}
+	other := &Log{Address: l.Address, Topics: []libcommon.Hash{TransferSig, {1}}, Data: []byte{1}, BlockNumber: 5}
+	if other.Bucket(16) != l.Bucket(16) {
+		t.Error("bucket depends on more than the address and signature")
+	}
+
+	const n = 16
+	counts := make([]int, n)
+	var total int
+	for i := 0; i < 4096; i++ {
+		var addr libcommon.Address
+		binary.BigEndian.PutUint16(addr[18:], uint16(i))
+		for _, sig := range []libcommon.Hash{TransferSig, ApprovalSig} {
+			counts[(&Log{Address: addr, Topics: []libcommon.Hash{sig}}).Bucket(n)]++
+			total++
+		}
+	}
+	// each bucket expects 512 logs with a standard deviation of about 22
+	for b, c := range counts {
+		if expected := total / n; c < expected*3/4 || c > expected*5/4 {
+			t.Errorf("bucket %d holds %d of %d logs", b, c, total)
+		}
+	}
+}
+
+func TestFilterDataRegexp(t *testing.T) {
+	t.Parallel()
+	logs := Logs{
+		{Index: 0, Data: libcommon.FromHex("0x000000000000000000000000c0ee9db1a9e07ca63e4ff0d5fb6f86bf68d47b89")},
+		{Index: 1, Data: libcommon.FromHex("0x00000000000000000000000000000000000000000001819451f999d617dafa93")},
+		{Index: 2, Data: libcommon.FromHex("0xdeadbeef")},
+		{Index: 3},
+	}
+	// a left-padded address: 12 zero bytes then 20 bytes
+	paddedAddr := regexp.MustCompile(`^(00){12}[0-9a-f]{40}$`)
+	if got := testFLExtractIndex(logs.FilterDataRegexp(paddedAddr, 0)); !reflect.DeepEqual(got, []uint{0, 1}) {
+		t.Errorf("padded address: got %v, want [0 1]", got)
+	}
+	if got := testFLExtractIndex(logs.FilterDataRegexp(regexp.MustCompile(`dead`), 0)); !reflect.DeepEqual(got, []uint{2}) {
+		t.Errorf("dead: got %v, want [2]", got)
+	}
+	if got := logs.FilterDataRegexp(regexp.MustCompile(`DEAD|0x`), 0); len(got) != 0 {
+		t.Errorf("uppercase or prefixed pattern: got %v, want nothing", testFLExtractIndex(got))
+	}
+	if got := testFLExtractIndex(logs.FilterDataRegexp(regexp.MustCompile(`^$`), 0)); !reflect.DeepEqual(got, []uint{3}) {
+		t.Errorf("empty data: got %v, want [3]", got)
+	}
+	if got := testFLExtractIndex(logs.FilterDataRegexp(regexp.MustCompile(``), 2)); !reflect.DeepEqual(got, []uint{0, 1}) {
+		t.Errorf("maxLogs 2: got %v, want [0 1]", got)
+	}
+}
+
+func TestTopicFilterConversions(t *testing.T) {
+	t.Parallel()
+	var a, b, c = libcommon.Hash{0xa}, libcommon.Hash{0xb}, libcommon.Hash{0xc}
+
+	flat := FlattenTopics([][]libcommon.Hash{{a, b}, nil, {c, a}})
+	if want := map[libcommon.Hash]struct{}{a: {}, b: {}, c: {}}; !reflect.DeepEqual(flat, want) {
+		t.Errorf("flatten: got %v, want %v", flat, want)
+	}
+	if got := FlattenTopics(nil); len(got) != 0 {
+		t.Errorf("flatten nil: got %v", got)
+	}
+	if got := FlattenTopics([][]libcommon.Hash{nil, {}}); len(got) != 0 {
+		t.Errorf("flatten wildcards: got %v", got)
+	}
+
+	if got, want := PositionalFromFlat(flat, 0), [][]libcommon.Hash{{a, b, c}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("position 0: got %v, want %v", got, want)
+	}
+	if got, want := PositionalFromFlat(map[libcommon.Hash]struct{}{b: {}}, 2), [][]libcommon.Hash{nil, nil, {b}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("position 2: got %v, want %v", got, want)
+	}
+	if got := PositionalFromFlat(nil, 1); got != nil {
+		t.Errorf("empty flat: got %v", got)
+	}
+	if got := PositionalFromFlat(flat, -1); got != nil {
+		t.Errorf("negative position: got %v", got)
+	}
+
+	// the flat set of a single position filter matches the same logs at that position
+	logs := Logs{
+		{Index: 0, Topics: []libcommon.Hash{a}},
+		{Index: 1, Topics: []libcommon.Hash{c, b}},
+		{Index: 2, Topics: []libcommon.Hash{b}},
+		{Index: 3},
+	}
+	positional := [][]libcommon.Hash{{a, b}}
+	want := logs.Filter(nil, positional, 0)
+	if got := logs.Filter(nil, PositionalFromFlat(FlattenTopics(positional), 0), 0); !reflect.DeepEqual(testFLExtractIndex(got), testFLExtractIndex(want)) {
+		t.Errorf("round trip: got %v, want %v", testFLExtractIndex(got), testFLExtractIndex(want))
+	}
+	if got := logs.CointainTopics(nil, FlattenTopics(positional), 0); !reflect.DeepEqual(testFLExtractIndex(got), []uint{0, 1, 2}) {
+		t.Errorf("flat match: got %v, want [0 1 2]", testFLExtractIndex(got))
+	}
+}
@@ -502,6 +502,12 @@ func (r Receipts) DeriveFields(hash libcommon.Hash, number uint64, txs Transacti
 // data and contextual infos like containing block and transactions.
 func (r *Receipt) DeriveFieldsV3ForSingleReceipt(txnIdx int, blockHash libcommon.Hash, blockNum uint64, txn Transaction, prevCumulativeGasUsed uint64) error {
 	logIndex := r.FirstLogIndexWithinBlock // logIdx is unique within the block and starts from 0
+	if txnIdx < 0 || uint64(txnIdx) > MaxLogIndex {
+		return fmt.Errorf("%w: txn index %d", ErrLogIndexOverflow, txnIdx)
+	}
+	if len(r.Logs) > 0 && uint64(logIndex)+uint64(len(r.Logs))-1 > MaxLogIndex {
+		return fmt.Errorf("%w: %d logs from index %d", ErrLogIndexOverflow, len(r.Logs), logIndex)
+	}
 
 	sender, ok := txn.cachedSender()
 	if !ok {
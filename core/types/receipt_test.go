@@ -337,6 +337,18 @@ func TestDeriveFields(t *testing.T) {
 
 }
 
+func TestDeriveFieldsV3ForSingleReceiptIndexOverflow(t *testing.T) {
+	t.Parallel()
+	// the first log index is a uint32, numbering past it would wrap around
+	r := &Receipt{FirstLogIndexWithinBlock: math.MaxUint32, Logs: []*Log{{}, {}}}
+	if err := r.DeriveFieldsV3ForSingleReceipt(0, libcommon.Hash{}, 1, nil, 0); !errors.Is(err, ErrLogIndexOverflow) {
+		t.Fatalf("got %v, want ErrLogIndexOverflow", err)
+	}
+	if err := (&Receipt{}).DeriveFieldsV3ForSingleReceipt(-1, libcommon.Hash{}, 1, nil, 0); !errors.Is(err, ErrLogIndexOverflow) {
+		t.Fatalf("negative txn index: got %v, want ErrLogIndexOverflow", err)
+	}
+}
+
 // TestTypedReceiptEncodingDecoding reproduces a flaw that existed in the receipt
 // rlp decoder, which failed due to a shadowing error.
 func TestTypedReceiptEncodingDecoding(t *testing.T) {
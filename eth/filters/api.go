@@ -23,14 +23,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"slices"
 
 	"github.com/erigontech/erigon-lib/common/hexutil"
 
 	libcommon "github.com/erigontech/erigon-lib/common"
 	"github.com/erigontech/erigon-lib/common/length"
+	"github.com/erigontech/erigon-lib/rlp"
 
 	ethereum "github.com/erigontech/erigon"
+	"github.com/erigontech/erigon/core/types"
 	"github.com/erigontech/erigon/rpc"
 )
 
@@ -38,6 +42,149 @@ import (
 // Same as ethereum.FilterQuery but with UnmarshalJSON() method.
 type FilterCriteria ethereum.FilterQuery
 
+// IncludeRemoved reports whether logs reverted by a chain reorganisation are matched.
+// It defaults to true when FilterIncludeRemoved is not set.
+func (crit FilterCriteria) IncludeRemoved() bool {
+	return crit.FilterIncludeRemoved == nil || *crit.FilterIncludeRemoved
+}
+
+// FilterLogs returns the logs matching the address and topic criteria. Logs with
+// Removed set are excluded unless IncludeRemoved is true. maxLogs limits the number
+// of returned logs, zero means no limit.
+func (crit FilterCriteria) FilterLogs(logs types.Logs, maxLogs uint64) types.Logs {
+	addrMap := crit.addrMap()
+	if crit.IncludeRemoved() {
+		o, _ := logs.FilterWithScanCount(addrMap, crit.Topics, maxLogs)
+		return o
+	}
+	canonical := make(types.Logs, 0, len(logs))
+	for _, l := range logs {
+		if !l.Removed {
+			canonical = append(canonical, l)
+		}
+	}
+	o, _ := canonical.FilterWithScanCount(addrMap, crit.Topics, maxLogs)
+	return o
+}
+
+// maxTopicPositions is the number of topics a log can carry, so criteria with more
+// positions can never match.
+const maxTopicPositions = 4
+
+// ValidateFilterCriteria rejects criteria that can never match, so that RPC handlers
+// can fail early with a clear message instead of returning nothing. Mixing BlockHash
+// with FromBlock/ToBlock is already rejected by UnmarshalJSON.
+func ValidateFilterCriteria(c FilterCriteria) error {
+	if len(c.Topics) > maxTopicPositions {
+		return fmt.Errorf("too many topic positions: %d, a log has at most %d topics", len(c.Topics), maxTopicPositions)
+	}
+	return nil
+}
+
+// MayMatchBloom reports whether a block with the given bloom can hold a log matching
+// the address and topic criteria. A false result is definitive, a true one may be a
+// false positive.
+func (crit FilterCriteria) MayMatchBloom(bloom types.Bloom) bool {
+	if len(crit.Addresses) > 0 && !slices.ContainsFunc(crit.Addresses, func(addr libcommon.Address) bool {
+		return bloom.Test(addr[:])
+	}) {
+		return false
+	}
+	for _, alternatives := range crit.Topics {
+		if len(alternatives) > 0 && !slices.ContainsFunc(alternatives, func(topic libcommon.Hash) bool {
+			return bloom.Test(topic[:])
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// EstimateBloomFalsePositives returns the share of blocks without a matching log,
+// according to actualMatches, that the bloom check would still have to scan. blooms
+// and actualMatches are paired by position and pairs past the shorter slice are
+// ignored. It returns 0 when no block is a non-match.
+func EstimateBloomFalsePositives(blooms []types.Bloom, c FilterCriteria, actualMatches []bool) float64 {
+	var negatives, falsePositives int
+	for i := 0; i < len(blooms) && i < len(actualMatches); i++ {
+		if actualMatches[i] {
+			continue
+		}
+		negatives++
+		if c.MayMatchBloom(blooms[i]) {
+			falsePositives++
+		}
+	}
+	if negatives == 0 {
+		return 0
+	}
+	return float64(falsePositives) / float64(negatives)
+}
+
+// FilterToRLPStream writes the RLP encoding of each log matching the criteria to w,
+// one after another, and returns the number of logs written. It follows the
+// matching rules of FilterLogs without collecting the matches. Buffered writers are
+// not flushed.
+func (crit FilterCriteria) FilterToRLPStream(w io.Writer, logs types.Logs) (int, error) {
+	cf := types.CompileFilter(crit.addrMap(), crit.Topics)
+	includeRemoved := crit.IncludeRemoved()
+	var n int
+	for _, l := range logs {
+		if (l.Removed && !includeRemoved) || !cf.Match(l) {
+			continue
+		}
+		if err := rlp.Encode(w, l); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// FilterUnion returns the logs matching any of rules, each following the matching
+// rules of FilterLogs, in a single pass over logs. Logs appearing more than once,
+// by (BlockHash, TxHash, Index), are returned once. The result is in canonical
+// order and maxLogs limits its length, zero means no limit.
+func FilterUnion(logs types.Logs, rules []FilterCriteria, maxLogs uint64) types.Logs {
+	type logKey struct {
+		blockHash, txHash libcommon.Hash
+		index             uint
+	}
+	compiled := make([]*types.CompiledFilter, len(rules))
+	for i, rule := range rules {
+		compiled[i] = types.CompileFilter(rule.addrMap(), rule.Topics)
+	}
+	o := make(types.Logs, 0, len(logs))
+	seen := make(map[logKey]struct{})
+	for _, l := range logs {
+		key := logKey{l.BlockHash, l.TxHash, l.Index}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		for i, cf := range compiled {
+			if (l.Removed && !rules[i].IncludeRemoved()) || !cf.Match(l) {
+				continue
+			}
+			seen[key] = struct{}{}
+			o = append(o, l)
+			break
+		}
+	}
+	o.Sort()
+	if maxLogs != 0 && uint64(len(o)) > maxLogs {
+		o = o[:maxLogs]
+	}
+	return o
+}
+
+func (crit FilterCriteria) addrMap() map[libcommon.Address]struct{} {
+	addrMap := make(map[libcommon.Address]struct{}, len(crit.Addresses))
+	for _, addr := range crit.Addresses {
+		addrMap[addr] = struct{}{}
+	}
+	return addrMap
+}
+
 type LogFilterOptions struct {
 	LogCount          uint64 `json:"logCount,omitempty"`
 	BlockCount        uint64 `json:"blockCount,omitempty"`
@@ -489,6 +636,8 @@ func (args *FilterCriteria) UnmarshalJSON(data []byte) error {
 		ToBlock   *rpc.BlockNumber `json:"toBlock"`
 		Addresses interface{}      `json:"address"`
 		Topics    []interface{}    `json:"topics"`
+
+		IncludeRemoved *bool `json:"includeRemoved"`
 	}
 
 	var raw input
@@ -513,6 +662,7 @@ func (args *FilterCriteria) UnmarshalJSON(data []byte) error {
 	}
 
 	args.Addresses = []libcommon.Address{}
+	args.FilterIncludeRemoved = raw.IncludeRemoved
 
 	if raw.Addresses != nil {
 		// raw.Address can contain a single address or an array of addresses
@@ -584,6 +734,21 @@ func (args *FilterCriteria) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// BuildFilterFromJSON parses the {address, topics} filter object shared by
+// eth_getLogs and eth_subscribe("logs") into a FilterCriteria.
+// Address may be a single address or an array, topics an array whose elements
+// are null, a single topic or an array of alternatives.
+func BuildFilterFromJSON(raw json.RawMessage) (FilterCriteria, error) {
+	var crit FilterCriteria
+	if len(raw) == 0 {
+		return crit, errors.New("empty filter criteria")
+	}
+	if err := json.Unmarshal(raw, &crit); err != nil {
+		return FilterCriteria{}, err
+	}
+	return crit, nil
+}
+
 func decodeAddress(s string) (libcommon.Address, error) {
 	b, err := hexutil.Decode(s)
 	if err == nil && len(b) != length.Addr {
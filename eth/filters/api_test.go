@@ -20,12 +20,17 @@
 package filters
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"reflect"
 	"testing"
 
 	libcommon "github.com/erigontech/erigon-lib/common"
+	"github.com/erigontech/erigon-lib/rlp"
 
+	"github.com/erigontech/erigon/core/types"
 	"github.com/erigontech/erigon/rpc"
 )
 
@@ -187,3 +192,307 @@ func TestUnmarshalJSONNewFilterArgs(t *testing.T) {
 		t.Fatalf("expected 0 topics, got %d topics", len(test7.Topics[2]))
 	}
 }
+
+func TestBuildFilterFromJSON(t *testing.T) {
+	var (
+		usdt     = libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+		usdc     = libcommon.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+		transfer = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+		approval = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+		holder   = libcommon.HexToHash("0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60")
+	)
+
+	tests := []struct {
+		name      string
+		input     string
+		addresses []libcommon.Address
+		topics    [][]libcommon.Hash
+		wantErr   bool
+	}{
+		{
+			name:      "scalar address and topic",
+			input:     `{"address":"0xdac17f958d2ee523a2206206994597c13d831ec7","topics":["0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"]}`,
+			addresses: []libcommon.Address{usdt},
+			topics:    [][]libcommon.Hash{{transfer}},
+		},
+		{
+			name:      "address array with null and scalar topics",
+			input:     `{"fromBlock":"0x1312d00","toBlock":"0x1312d64","address":["0xdac17f958d2ee523a2206206994597c13d831ec7","0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"],"topics":["0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",null,"0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"]}`,
+			addresses: []libcommon.Address{usdt, usdc},
+			topics:    [][]libcommon.Hash{{transfer}, nil, {holder}},
+		},
+		{
+			name:      "nested alternatives",
+			input:     `{"topics":[["0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef","0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"],["0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"]]}`,
+			addresses: []libcommon.Address{},
+			topics:    [][]libcommon.Hash{{transfer, approval}, {holder}},
+		},
+		{
+			name:      "null inside alternatives is a wildcard",
+			input:     `{"topics":[null,["0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60",null]]}`,
+			addresses: []libcommon.Address{},
+			topics:    [][]libcommon.Hash{nil, nil},
+		},
+		{
+			name:      "no criteria",
+			input:     `{}`,
+			addresses: []libcommon.Address{},
+		},
+		{name: "empty input", input: ``, wantErr: true},
+		{name: "short address", input: `{"address":"0xdac17f958d2ee523a2206206994597c13d831e"}`, wantErr: true},
+		{name: "short topic", input: `{"topics":["0xddf252ad"]}`, wantErr: true},
+		{name: "numeric topic", input: `{"topics":[1]}`, wantErr: true},
+		{name: "numeric address", input: `{"address":1}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		crit, err := BuildFilterFromJSON(json.RawMessage(tt.input))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(crit.Addresses, tt.addresses) {
+			t.Errorf("%s: addresses got %v, want %v", tt.name, crit.Addresses, tt.addresses)
+		}
+		if !reflect.DeepEqual(crit.Topics, tt.topics) {
+			t.Errorf("%s: topics got %v, want %v", tt.name, crit.Topics, tt.topics)
+		}
+	}
+}
+
+func TestFilterCriteriaIncludeRemoved(t *testing.T) {
+	var (
+		addr  = libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+		topic = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	)
+	logs := types.Logs{
+		{Address: addr, Topics: []libcommon.Hash{topic}, Index: 0},
+		{Address: addr, Topics: []libcommon.Hash{topic}, Index: 1, Removed: true},
+		{Address: addr, Topics: []libcommon.Hash{topic}, Index: 2},
+	}
+	indices := func(logs types.Logs) (o []uint) {
+		for _, l := range logs {
+			o = append(o, l.Index)
+		}
+		return o
+	}
+
+	crit := FilterCriteria{Addresses: []libcommon.Address{addr}, Topics: [][]libcommon.Hash{{topic}}}
+	if !crit.IncludeRemoved() {
+		t.Fatal("removed logs must be included by default")
+	}
+	if got := indices(crit.FilterLogs(logs, 0)); !reflect.DeepEqual(got, []uint{0, 1, 2}) {
+		t.Fatalf("default: got %v", got)
+	}
+
+	include := true
+	crit.FilterIncludeRemoved = &include
+	if got := indices(crit.FilterLogs(logs, 0)); !reflect.DeepEqual(got, []uint{0, 1, 2}) {
+		t.Fatalf("include removed: got %v", got)
+	}
+
+	exclude := false
+	crit.FilterIncludeRemoved = &exclude
+	if got := indices(crit.FilterLogs(logs, 0)); !reflect.DeepEqual(got, []uint{0, 2}) {
+		t.Fatalf("exclude removed: got %v", got)
+	}
+	var parsed FilterCriteria
+	if err := json.Unmarshal([]byte(`{"includeRemoved":false}`), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.IncludeRemoved() {
+		t.Fatal("includeRemoved false was not parsed")
+	}
+	if err := json.Unmarshal([]byte(`{}`), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.FilterIncludeRemoved != nil {
+		t.Fatal("includeRemoved must be unset when absent")
+	}
+
+	// maxLogs limits returned logs, so the skipped removed log does not count
+	if got := indices(crit.FilterLogs(logs, 2)); !reflect.DeepEqual(got, []uint{0, 2}) {
+		t.Fatalf("exclude removed, maxLogs 2: got %v", got)
+	}
+	if got := indices(crit.FilterLogs(logs, 1)); !reflect.DeepEqual(got, []uint{0}) {
+		t.Fatalf("exclude removed, maxLogs 1: got %v", got)
+	}
+}
+
+func TestValidateFilterCriteria(t *testing.T) {
+	var (
+		blockHash = libcommon.HexToHash("0x88e96d4537bea4d9c05d12549907b32561d3bf31f45aae734cdc119f13406cb6")
+		transfer  = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+		approval  = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	)
+	tests := []struct {
+		name    string
+		crit    FilterCriteria
+		wantErr string
+	}{
+		{name: "empty", crit: FilterCriteria{}},
+		{name: "four positions", crit: FilterCriteria{Topics: [][]libcommon.Hash{{transfer, approval}, nil, {}, {transfer}}}},
+		{name: "block hash", crit: FilterCriteria{BlockHash: &blockHash}},
+		{name: "range", crit: FilterCriteria{FromBlock: big.NewInt(1), ToBlock: big.NewInt(2)}},
+		{
+			name:    "five positions",
+			crit:    FilterCriteria{Topics: [][]libcommon.Hash{{transfer}, nil, nil, nil, nil}},
+			wantErr: "too many topic positions: 5, a log has at most 4 topics",
+		},
+		// repeating an alternative is redundant but valid
+		{name: "duplicate topic", crit: FilterCriteria{Topics: [][]libcommon.Hash{nil, {approval, transfer, approval}}}},
+	}
+	for _, tt := range tests {
+		err := ValidateFilterCriteria(tt.crit)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// mixing a block hash with a range is rejected while decoding the criteria
+	var crit FilterCriteria
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{"blockHash":"%s","fromBlock":"0x1"}`, blockHash.Hex())), &crit)
+	if want := "cannot specify both BlockHash and FromBlock/ToBlock, choose one or the other"; err == nil || err.Error() != want {
+		t.Errorf("block hash and range: got error %v, want %q", err, want)
+	}
+}
+
+func TestEstimateBloomFalsePositives(t *testing.T) {
+	var (
+		usdt     = libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+		usdc     = libcommon.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+		transfer = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+		approval = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	)
+	bloom := func(logs ...*types.Log) types.Bloom {
+		return types.BytesToBloom(types.LogsBloom(logs))
+	}
+	crit := FilterCriteria{Addresses: []libcommon.Address{usdt}, Topics: [][]libcommon.Hash{{transfer}}}
+	blooms := []types.Bloom{
+		bloom(&types.Log{Address: usdt, Topics: []libcommon.Hash{transfer}}), // match
+		bloom(&types.Log{Address: usdc, Topics: []libcommon.Hash{transfer}}), // wrong address
+		// both the address and the topic are present but in different logs
+		bloom(&types.Log{Address: usdt, Topics: []libcommon.Hash{approval}}, &types.Log{Address: usdc, Topics: []libcommon.Hash{transfer}}),
+		bloom(&types.Log{Address: usdt, Topics: []libcommon.Hash{transfer}}), // match removed by a later check
+		{}, // no logs
+	}
+	actual := []bool{true, false, false, false, false}
+
+	wantMay := []bool{true, false, true, true, false}
+	for i, b := range blooms {
+		if got := crit.MayMatchBloom(b); got != wantMay[i] {
+			t.Errorf("block %d: bloom match got %v, want %v", i, got, wantMay[i])
+		}
+	}
+	if got := EstimateBloomFalsePositives(blooms, crit, actual); got != 0.5 {
+		t.Errorf("got false positive rate %v, want 0.5", got)
+	}
+	if got := EstimateBloomFalsePositives(blooms[:1], crit, actual); got != 0 {
+		t.Errorf("no negatives: got %v, want 0", got)
+	}
+	if got := EstimateBloomFalsePositives(blooms, FilterCriteria{}, actual[:3]); got != 1 {
+		t.Errorf("wildcard: got %v, want 1", got)
+	}
+}
+
+func TestFilterToRLPStream(t *testing.T) {
+	var (
+		usdt     = libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+		usdc     = libcommon.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+		transfer = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+		approval = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	)
+	logs := types.Logs{
+		{Address: usdt, Topics: []libcommon.Hash{transfer}, Data: []byte{1}},
+		{Address: usdc, Topics: []libcommon.Hash{transfer}, Data: []byte{2}},
+		{Address: usdt, Topics: []libcommon.Hash{approval}, Data: []byte{3}},
+		{Address: usdt, Topics: []libcommon.Hash{transfer}, Data: []byte{4}, Removed: true},
+		{Address: usdt, Topics: []libcommon.Hash{transfer, approval}, Data: []byte{5}},
+	}
+	exclude := false
+	for _, crit := range []FilterCriteria{
+		{},
+		{Addresses: []libcommon.Address{usdt}, Topics: [][]libcommon.Hash{{transfer}}},
+		{Addresses: []libcommon.Address{usdt}, Topics: [][]libcommon.Hash{{transfer}}, FilterIncludeRemoved: &exclude},
+		{Addresses: []libcommon.Address{usdc}, Topics: [][]libcommon.Hash{{approval}}},
+	} {
+		var buf bytes.Buffer
+		n, err := crit.FilterToRLPStream(&buf, logs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := crit.FilterLogs(logs, 0)
+		if n != len(want) {
+			t.Fatalf("%+v: wrote %d logs, want %d", crit, n, len(want))
+		}
+		s := rlp.NewStream(&buf, 0)
+		for i := range want {
+			var l types.Log
+			if err := s.Decode(&l); err != nil {
+				t.Fatalf("%+v: log %d: %v", crit, i, err)
+			}
+			if l.Address != want[i].Address || !reflect.DeepEqual(l.Topics, want[i].Topics) || !bytes.Equal(l.Data, want[i].Data) {
+				t.Errorf("%+v: log %d: got %v, want %v", crit, i, &l, want[i])
+			}
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%+v: %d trailing bytes", crit, buf.Len())
+		}
+	}
+}
+
+func TestFilterUnion(t *testing.T) {
+	var (
+		usdt     = libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+		usdc     = libcommon.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+		dai      = libcommon.HexToAddress("0x6b175474e89094c44da98b954eedeac495271d0f")
+		transfer = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+		approval = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	)
+	log := func(block uint64, index uint, addr libcommon.Address, sig libcommon.Hash) *types.Log {
+		return &types.Log{BlockNumber: block, BlockHash: libcommon.Hash{byte(block)}, Index: index, Address: addr, Topics: []libcommon.Hash{sig}}
+	}
+	logs := types.Logs{
+		log(2, 0, usdt, transfer),
+		log(1, 0, usdt, transfer),
+		log(1, 1, usdc, approval),
+		log(1, 2, usdc, transfer),
+		log(1, 3, dai, transfer),
+		log(2, 1, usdt, approval),
+	}
+	logs = append(logs, logs[1]) // delivered twice
+	rules := []FilterCriteria{
+		{Addresses: []libcommon.Address{usdt}, Topics: [][]libcommon.Hash{{transfer}}},
+		{Addresses: []libcommon.Address{usdt, usdc}, Topics: [][]libcommon.Hash{{approval}}},
+		{Addresses: []libcommon.Address{usdt, usdc}, Topics: [][]libcommon.Hash{{transfer}}}, // overlaps the first rule
+	}
+	type pos struct {
+		block uint64
+		index uint
+	}
+	positions := func(logs types.Logs) (o []pos) {
+		for _, l := range logs {
+			o = append(o, pos{l.BlockNumber, l.Index})
+		}
+		return o
+	}
+	want := []pos{{1, 0}, {1, 1}, {1, 2}, {2, 0}, {2, 1}}
+	if got := positions(FilterUnion(logs, rules, 0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := positions(FilterUnion(logs, rules, 2)); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("maxLogs 2: got %v, want %v", got, want[:2])
+	}
+	if got := FilterUnion(logs, nil, 0); len(got) != 0 {
+		t.Errorf("no rules: got %v", positions(got))
+	}
+}
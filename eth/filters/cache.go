@@ -0,0 +1,128 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"encoding/binary"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+
+	libcommon "github.com/erigontech/erigon-lib/common"
+	"github.com/erigontech/erigon-lib/common/length"
+	"github.com/erigontech/erigon-lib/crypto"
+
+	"github.com/erigontech/erigon/core/types"
+)
+
+// FilterCache caches the results of FilterCriteria.FilterLogs for clients polling
+// the same query. It has two levels, both bounded and evicting the least recently
+// used entries: compiled criteria keyed by a fingerprint of the criteria, and
+// results keyed by the fingerprint and the identity of the filtered slice.
+//
+// The identity of a slice is the address of its first element and its length, not
+// its content. Results are therefore stale once logs are modified in place or their
+// backing array is reused for other logs; call Purge after doing so. Appending to
+// a slice changes its length and never hits entries of the shorter slice. Entries
+// keep their input slices reachable until evicted.
+//
+// It is safe for concurrent use.
+type FilterCache struct {
+	results  *lru.Cache[filterCacheKey, types.Logs]
+	matchers *lru.Cache[libcommon.Hash, cachedMatcher]
+}
+
+type filterCacheKey struct {
+	fingerprint libcommon.Hash
+	first       **types.Log
+	n           int
+}
+
+type cachedMatcher struct {
+	cf             *types.CompiledFilter
+	includeRemoved bool
+}
+
+// NewFilterCache returns a FilterCache holding at most size results and size
+// compiled criteria.
+func NewFilterCache(size int) (*FilterCache, error) {
+	results, err := lru.New[filterCacheKey, types.Logs](size)
+	if err != nil {
+		return nil, err
+	}
+	matchers, err := lru.New[libcommon.Hash, cachedMatcher](size)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterCache{results: results, matchers: matchers}, nil
+}
+
+// Filter returns criteria.FilterLogs(logs, 0), from the cache when the same criteria
+// were applied to the same slice before. Cached results are shared between callers
+// and must not be modified.
+func (c *FilterCache) Filter(logs types.Logs, criteria FilterCriteria) types.Logs {
+	if len(logs) == 0 {
+		return types.Logs{}
+	}
+	fp := criteria.fingerprint()
+	key := filterCacheKey{fingerprint: fp, first: &logs[0], n: len(logs)}
+	if o, ok := c.results.Get(key); ok {
+		return o
+	}
+	m, ok := c.matchers.Get(fp)
+	if !ok {
+		m = cachedMatcher{cf: types.CompileFilter(criteria.addrMap(), criteria.Topics), includeRemoved: criteria.IncludeRemoved()}
+		c.matchers.Add(fp, m)
+	}
+	o := make(types.Logs, 0, len(logs))
+	for _, l := range logs {
+		if (!l.Removed || m.includeRemoved) && m.cf.Match(l) {
+			o = append(o, l)
+		}
+	}
+	c.results.Add(key, o)
+	return o
+}
+
+// Purge drops all cached results and compiled criteria.
+func (c *FilterCache) Purge() {
+	c.results.Purge()
+	c.matchers.Purge()
+}
+
+// fingerprint returns the keccak256 hash of the fields of the criteria used to match
+// logs: the addresses, the topics and whether removed logs are included. The block
+// range is not part of it.
+func (crit FilterCriteria) fingerprint() libcommon.Hash {
+	buf := make([]byte, 0, 1+4+len(crit.Addresses)*length.Addr+4+len(crit.Topics)*(4+length.Hash))
+	if crit.IncludeRemoved() {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(crit.Addresses)))
+	for _, addr := range crit.Addresses {
+		buf = append(buf, addr[:]...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(crit.Topics)))
+	for _, alternatives := range crit.Topics {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(alternatives)))
+		for _, topic := range alternatives {
+			buf = append(buf, topic[:]...)
+		}
+	}
+	return crypto.Keccak256Hash(buf)
+}
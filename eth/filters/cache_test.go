@@ -0,0 +1,122 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"reflect"
+	"testing"
+
+	libcommon "github.com/erigontech/erigon-lib/common"
+
+	"github.com/erigontech/erigon/core/types"
+)
+
+func TestFilterCache(t *testing.T) {
+	var (
+		usdt     = libcommon.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+		usdc     = libcommon.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+		transfer = libcommon.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+		approval = libcommon.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	)
+	logs := types.Logs{
+		{Address: usdt, Topics: []libcommon.Hash{transfer}, Index: 0},
+		{Address: usdc, Topics: []libcommon.Hash{transfer}, Index: 1},
+		{Address: usdt, Topics: []libcommon.Hash{approval}, Index: 2},
+		{Address: usdt, Topics: []libcommon.Hash{transfer}, Index: 3, Removed: true},
+	}
+	exclude := false
+	criteria := []FilterCriteria{
+		{Addresses: []libcommon.Address{usdt}, Topics: [][]libcommon.Hash{{transfer}}},
+		{Addresses: []libcommon.Address{usdt}, Topics: [][]libcommon.Hash{{transfer}}, FilterIncludeRemoved: &exclude},
+		{Topics: [][]libcommon.Hash{{transfer, approval}}},
+		{},
+	}
+	c, err := NewFilterCache(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, crit := range criteria {
+		want := crit.FilterLogs(logs, 0)
+		first := c.Filter(logs, crit)
+		if !reflect.DeepEqual(first, want) {
+			t.Fatalf("criteria %d: got %v, want %v", i, first, want)
+		}
+		second := c.Filter(logs, crit)
+		if !reflect.DeepEqual(second, want) || &second[0] != &first[0] {
+			t.Errorf("criteria %d: second call was not served from the cache", i)
+		}
+	}
+	if got := c.results.Len(); got != len(criteria) {
+		t.Errorf("got %d cached results, want %d", got, len(criteria))
+	}
+
+	// the same criteria on a longer slice is another entry
+	more := append(logs[:len(logs):len(logs)], &types.Log{Address: usdt, Topics: []libcommon.Hash{transfer}, Index: 4})
+	if got := c.Filter(more, criteria[0]); len(got) != 3 {
+		t.Errorf("longer slice: got %d logs, want 3", len(got))
+	}
+
+	// modifying logs in place serves stale results until purged
+	logs[1].Address = usdt
+	if got := c.Filter(logs, criteria[0]); len(got) != 2 {
+		t.Errorf("before purge: got %d logs, want the 2 cached ones", len(got))
+	}
+	c.Purge()
+	if got := c.Filter(logs, criteria[0]); len(got) != 3 {
+		t.Errorf("after purge: got %d logs, want 3", len(got))
+	}
+
+	small, err := NewFilterCache(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, crit := range criteria {
+		small.Filter(logs, crit)
+	}
+	if got := small.results.Len(); got != 2 {
+		t.Errorf("bounded cache holds %d results, want 2", got)
+	}
+	if _, err := NewFilterCache(0); err == nil {
+		t.Error("zero size cache created")
+	}
+}
+
+func TestFilterCriteriaFingerprint(t *testing.T) {
+	var a, b = libcommon.Hash{0xa}, libcommon.Hash{0xb}
+	exclude := false
+	distinct := []FilterCriteria{
+		{},
+		{Topics: [][]libcommon.Hash{{a}}},
+		{Topics: [][]libcommon.Hash{{a}, {}}},
+		{Topics: [][]libcommon.Hash{{a, b}}},
+		{Topics: [][]libcommon.Hash{{a}, {b}}},
+		{Addresses: []libcommon.Address{{0xa}}},
+		{FilterIncludeRemoved: &exclude},
+	}
+	seen := map[libcommon.Hash]int{}
+	for i, crit := range distinct {
+		fp := crit.fingerprint()
+		if j, ok := seen[fp]; ok {
+			t.Errorf("criteria %d and %d share a fingerprint", j, i)
+		}
+		seen[fp] = i
+	}
+	include := true
+	if (FilterCriteria{FilterIncludeRemoved: &include}).fingerprint() != (FilterCriteria{}).fingerprint() {
+		t.Error("explicit default changed the fingerprint")
+	}
+}
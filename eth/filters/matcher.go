@@ -0,0 +1,173 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"slices"
+	"sync"
+
+	libcommon "github.com/erigontech/erigon-lib/common"
+
+	"github.com/erigontech/erigon/core/types"
+)
+
+// SubscriptionMatcher matches logs against a large set of log filters. Filters are
+// indexed by address, or by first topic when they don't restrict addresses, so a log
+// is only checked against the filters that can possibly match it.
+type SubscriptionMatcher struct {
+	mu       sync.RWMutex
+	filters  map[string]*matcherFilter
+	byAddr   map[libcommon.Address][]*matcherFilter
+	byTopic0 map[libcommon.Hash][]*matcherFilter
+	wildcard []*matcherFilter
+}
+
+type matcherFilter struct {
+	id             string
+	addresses      map[libcommon.Address]struct{}
+	topics         []map[libcommon.Hash]struct{}
+	includeRemoved bool
+}
+
+// NewSubscriptionMatcher returns an empty matcher, filters are registered with AddFilter.
+func NewSubscriptionMatcher() *SubscriptionMatcher {
+	return &SubscriptionMatcher{
+		filters:  map[string]*matcherFilter{},
+		byAddr:   map[libcommon.Address][]*matcherFilter{},
+		byTopic0: map[libcommon.Hash][]*matcherFilter{},
+	}
+}
+
+// AddFilter registers the criteria c under id, replacing any filter previously added with the same id.
+func (m *SubscriptionMatcher) AddFilter(id string, c FilterCriteria) {
+	f := &matcherFilter{
+		id:             id,
+		addresses:      make(map[libcommon.Address]struct{}, len(c.Addresses)),
+		topics:         make([]map[libcommon.Hash]struct{}, len(c.Topics)),
+		includeRemoved: c.IncludeRemoved(),
+	}
+	for _, addr := range c.Addresses {
+		f.addresses[addr] = struct{}{}
+	}
+	for i, set := range c.Topics {
+		if len(set) == 0 {
+			continue
+		}
+		f.topics[i] = make(map[libcommon.Hash]struct{}, len(set))
+		for _, topic := range set {
+			f.topics[i][topic] = struct{}{}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	m.filters[id] = f
+	switch {
+	case len(f.addresses) > 0:
+		for addr := range f.addresses {
+			m.byAddr[addr] = append(m.byAddr[addr], f)
+		}
+	case len(f.topics) > 0 && f.topics[0] != nil:
+		for topic := range f.topics[0] {
+			m.byTopic0[topic] = append(m.byTopic0[topic], f)
+		}
+	default:
+		m.wildcard = append(m.wildcard, f)
+	}
+}
+
+// RemoveFilter unregisters the filter added under id, if any.
+func (m *SubscriptionMatcher) RemoveFilter(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+}
+
+func (m *SubscriptionMatcher) remove(id string) {
+	f, ok := m.filters[id]
+	if !ok {
+		return
+	}
+	delete(m.filters, id)
+	drop := func(fs []*matcherFilter) []*matcherFilter {
+		return slices.DeleteFunc(fs, func(g *matcherFilter) bool { return g == f })
+	}
+	switch {
+	case len(f.addresses) > 0:
+		for addr := range f.addresses {
+			if m.byAddr[addr] = drop(m.byAddr[addr]); len(m.byAddr[addr]) == 0 {
+				delete(m.byAddr, addr)
+			}
+		}
+	case len(f.topics) > 0 && f.topics[0] != nil:
+		for topic := range f.topics[0] {
+			if m.byTopic0[topic] = drop(m.byTopic0[topic]); len(m.byTopic0[topic]) == 0 {
+				delete(m.byTopic0, topic)
+			}
+		}
+	default:
+		m.wildcard = drop(m.wildcard)
+	}
+}
+
+// Match returns the sorted IDs of the filters l satisfies, with the same semantics as FilterCriteria.FilterLogs.
+func (m *SubscriptionMatcher) Match(l *types.Log) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	check := func(fs []*matcherFilter) {
+		for _, f := range fs {
+			if f.matches(l) {
+				ids = append(ids, f.id)
+			}
+		}
+	}
+	// every filter sits in exactly one index and a log has a single address and
+	// first topic, so no filter is visited twice
+	check(m.byAddr[l.Address])
+	if len(l.Topics) > 0 {
+		check(m.byTopic0[l.Topics[0]])
+	}
+	check(m.wildcard)
+	slices.Sort(ids)
+	return ids
+}
+
+func (f *matcherFilter) matches(l *types.Log) bool {
+	if l.Removed && !f.includeRemoved {
+		return false
+	}
+	if len(f.addresses) > 0 {
+		if _, ok := f.addresses[l.Address]; !ok {
+			return false
+		}
+	}
+	if len(f.topics) > len(l.Topics) {
+		return false
+	}
+	for i, set := range f.topics {
+		if set == nil {
+			continue
+		}
+		if _, ok := set[l.Topics[i]]; !ok {
+			return false
+		}
+	}
+	return true
+}
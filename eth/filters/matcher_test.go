@@ -0,0 +1,112 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	libcommon "github.com/erigontech/erigon-lib/common"
+
+	"github.com/erigontech/erigon/core/types"
+)
+
+func TestSubscriptionMatcher(t *testing.T) {
+	t.Parallel()
+	var (
+		addr1    = libcommon.Address{1}
+		addr2    = libcommon.Address{2}
+		transfer = libcommon.Hash{1}
+		approval = libcommon.Hash{2}
+		owner    = libcommon.Hash{3}
+		no       = false
+	)
+	m := NewSubscriptionMatcher()
+	m.AddFilter("all", FilterCriteria{})
+	m.AddFilter("addr1", FilterCriteria{Addresses: []libcommon.Address{addr1}})
+	m.AddFilter("addr1or2-transfer", FilterCriteria{Addresses: []libcommon.Address{addr1, addr2}, Topics: [][]libcommon.Hash{{transfer}}})
+	m.AddFilter("transfer", FilterCriteria{Topics: [][]libcommon.Hash{{transfer}}})
+	m.AddFilter("transfer-or-approval-from-owner", FilterCriteria{Topics: [][]libcommon.Hash{{transfer, approval}, {owner}}})
+	m.AddFilter("any-from-owner", FilterCriteria{Topics: [][]libcommon.Hash{{}, {owner}}})
+	m.AddFilter("canonical-addr2", FilterCriteria{Addresses: []libcommon.Address{addr2}, FilterIncludeRemoved: &no})
+
+	tests := []struct {
+		name string
+		log  *types.Log
+		want []string
+	}{
+		{"addr1 transfer", &types.Log{Address: addr1, Topics: []libcommon.Hash{transfer}},
+			[]string{"addr1", "addr1or2-transfer", "all", "transfer"}},
+		{"addr2 approval from owner", &types.Log{Address: addr2, Topics: []libcommon.Hash{approval, owner}},
+			[]string{"all", "any-from-owner", "canonical-addr2", "transfer-or-approval-from-owner"}},
+		{"removed addr2 transfer", &types.Log{Address: addr2, Topics: []libcommon.Hash{transfer}, Removed: true},
+			[]string{"addr1or2-transfer", "all", "transfer"}},
+		{"anonymous", &types.Log{Address: libcommon.Address{9}},
+			[]string{"all"}},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.log); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		// the index must agree with evaluating every filter on its own
+		var want []string
+		for id, f := range m.filters {
+			if f.matches(tt.log) {
+				want = append(want, id)
+			}
+		}
+		if len(want) != len(tt.want) {
+			t.Errorf("%s: index matched %v, full scan matched %v", tt.name, tt.want, want)
+		}
+	}
+
+	// replacing and removing filters updates the index
+	m.AddFilter("addr1", FilterCriteria{Addresses: []libcommon.Address{addr2}})
+	m.RemoveFilter("all")
+	m.RemoveFilter("transfer")
+	got := m.Match(&types.Log{Address: addr1, Topics: []libcommon.Hash{transfer}})
+	if want := []string{"addr1or2-transfer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after update: got %v, want %v", got, want)
+	}
+}
+
+func BenchmarkSubscriptionMatcher(b *testing.B) {
+	m := NewSubscriptionMatcher()
+	for i := 0; i < 10_000; i++ {
+		var crit FilterCriteria
+		switch i % 3 {
+		case 0:
+			crit.Addresses = []libcommon.Address{{byte(i), byte(i >> 8)}}
+		case 1:
+			crit.Topics = [][]libcommon.Hash{{{byte(i), byte(i >> 8)}}}
+		default:
+			crit.Addresses = []libcommon.Address{{byte(i), byte(i >> 8)}}
+			crit.Topics = [][]libcommon.Hash{{{byte(i), byte(i >> 8)}}}
+		}
+		m.AddFilter(fmt.Sprint(i), crit)
+	}
+	logs := make([]*types.Log, 256)
+	for i := range logs {
+		logs[i] = &types.Log{Address: libcommon.Address{byte(i * 3)}, Topics: []libcommon.Hash{{byte(i*3 + 1)}}}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(logs[i%len(logs)])
+	}
+}
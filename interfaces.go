@@ -160,6 +160,11 @@ type FilterQuery struct {
 	// {{A}, {B}}         matches topic A in first position AND B in second position
 	// {{A, B}, {C, D}}   matches topic (A OR B) in first position AND (C OR D) in second position
 	Topics [][]libcommon.Hash
+
+	// FilterIncludeRemoved controls whether logs reverted by a chain reorganisation
+	// (Removed set to true) are matched. nil means true. RPC queries set it through
+	// the "includeRemoved" field.
+	FilterIncludeRemoved *bool
 }
 
 // LogFilterer provides access to contract log events using a one-off query or continuous
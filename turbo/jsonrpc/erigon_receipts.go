@@ -68,6 +68,9 @@ func (api *ErigonImpl) GetLogsByHash(ctx context.Context, hash common.Hash) ([][
 
 // GetLogs implements erigon_getLogs. Returns an array of logs matching a given filter object.
 func (api *ErigonImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria) (types.ErigonLogs, error) {
+	if err := filters.ValidateFilterCriteria(crit); err != nil {
+		return nil, err
+	}
 	var begin, end uint64
 	erigonLogs := types.ErigonLogs{}
 
@@ -116,7 +119,18 @@ func (api *ErigonImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria)
 		return nil, fmt.Errorf("end (%d) > MaxUint32", end)
 	}
 
-	return api.getLogsV3(ctx, tx, begin, end, crit)
+	logs, err := api.getLogsV3(ctx, tx, begin, end, crit)
+	if err != nil {
+		return nil, err
+	}
+	includeRemoved := crit.IncludeRemoved()
+	for _, log := range logs {
+		if log.Removed && !includeRemoved {
+			continue
+		}
+		erigonLogs = append(erigonLogs, log)
+	}
+	return erigonLogs, nil
 }
 
 // GetLatestLogs implements erigon_getLatestLogs.
@@ -131,6 +145,9 @@ func (api *ErigonImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria)
 // {} or nil          matches any topics list
 // {{A}}              matches topic A in any positions. Logs with {{B}, {A}} will be matched
 func (api *ErigonImpl) GetLatestLogs(ctx context.Context, crit filters.FilterCriteria, logOptions filters.LogFilterOptions) (types.ErigonLogs, error) {
+	if err := filters.ValidateFilterCriteria(crit); err != nil {
+		return nil, err
+	}
 	if logOptions.LogCount != 0 && logOptions.BlockCount != 0 {
 		return nil, errors.New("logs count & block count are ambigious")
 	}
@@ -202,6 +219,7 @@ func (api *ErigonImpl) GetLatestLogs(ctx context.Context, crit filters.FilterCri
 	for _, v := range crit.Addresses {
 		addrMap[v] = struct{}{}
 	}
+	includeRemoved := crit.IncludeRemoved()
 	topicsMap := make(map[common.Hash]struct{})
 	for i := range crit.Topics {
 		for j := range crit.Topics[i] {
@@ -264,6 +282,9 @@ func (api *ErigonImpl) GetLatestLogs(ctx context.Context, crit filters.FilterCri
 			log.Index = logIndex
 			logIndex++
 		}
+		if !includeRemoved {
+			blockLogs, _ = blockLogs.SplitByRemoved()
+		}
 		var filtered types.Logs
 		var maxLogCount uint64
 		maxLogCount = 0
@@ -117,6 +117,21 @@ func TestErigonGetLatestLogs(t *testing.T) {
 	assert.EqualValues(expectedLog, actual[0])
 }
 
+func TestErigonGetLogsInvalidCriteria(t *testing.T) {
+	m, _, _ := rpcdaemontest.CreateTestSentry(t)
+	api := NewErigonAPI(newBaseApiForTest(m), m.DB, nil)
+	crit := filters.FilterCriteria{
+		FromBlock: big.NewInt(0),
+		ToBlock:   big.NewInt(10),
+		Topics:    make([][]libcommon.Hash, 5),
+	}
+
+	_, err := api.GetLogs(m.Ctx, crit)
+	require.ErrorContains(t, err, "too many topic positions")
+	_, err = api.GetLatestLogs(m.Ctx, crit, filters.LogFilterOptions{})
+	require.ErrorContains(t, err, "too many topic positions")
+}
+
 func TestErigonGetLatestLogsIgnoreTopics(t *testing.T) {
 	assert := assert.New(t)
 	m, _, _ := rpcdaemontest.CreateTestSentry(t)
@@ -62,6 +62,9 @@ func (api *APIImpl) NewFilter(_ context.Context, crit filters.FilterCriteria) (s
 	if api.filters == nil {
 		return "", rpc.ErrNotificationsUnsupported
 	}
+	if err := filters.ValidateFilterCriteria(crit); err != nil {
+		return "", err
+	}
 	logs, id := api.filters.SubscribeLogs(256, crit)
 	go func() {
 		for lg := range logs {
@@ -274,6 +277,9 @@ func (api *APIImpl) Logs(ctx context.Context, crit filters.FilterCriteria) (*rpc
 	if !supported {
 		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
 	}
+	if err := filters.ValidateFilterCriteria(crit); err != nil {
+		return &rpc.Subscription{}, err
+	}
 
 	rpcSub := notifier.CreateSubscription()
 
@@ -65,6 +65,9 @@ func (api *BaseAPI) getCachedReceipts(ctx context.Context, hash common.Hash) (ty
 
 // GetLogs implements eth_getLogs. Returns an array of logs matching a given filter object.
 func (api *APIImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria) (types.Logs, error) {
+	if err := filters.ValidateFilterCriteria(crit); err != nil {
+		return nil, err
+	}
 	var begin, end uint64
 	logs := types.Logs{}
 
@@ -143,9 +146,13 @@ func (api *APIImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria) (t
 	if err != nil {
 		return nil, err
 	}
-	logs = make(types.Logs, len(erigonLogs))
-	for i, log := range erigonLogs {
-		logs[i] = &types.Log{
+	includeRemoved := crit.IncludeRemoved()
+	logs = make(types.Logs, 0, len(erigonLogs))
+	for _, log := range erigonLogs {
+		if log.Removed && !includeRemoved {
+			continue
+		}
+		logs = append(logs, &types.Log{
 			Address:     log.Address,
 			Topics:      log.Topics,
 			Data:        log.Data,
@@ -155,7 +162,7 @@ func (api *APIImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria) (t
 			BlockHash:   log.BlockHash,
 			Index:       log.Index,
 			Removed:     log.Removed,
-		}
+		})
 	}
 	return logs, nil
 }
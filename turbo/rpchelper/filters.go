@@ -444,6 +444,7 @@ func (ff *Filters) SubscribeLogs(size int, criteria filters.FilterCriteria) (<-c
 	// Initialize address and topic maps
 	f.addrs = concurrent.NewSyncMap[libcommon.Address, int]()
 	f.topics = concurrent.NewSyncMap[libcommon.Hash, int]()
+	f.excludeRemoved = !criteria.IncludeRemoved()
 
 	// Handle addresses
 	if len(criteria.Addresses) == 0 {
@@ -143,6 +143,34 @@ func TestFilters_SingleSubscription_EmptyTopicsInCriteria_OnlyTopicsSubscribedAr
 	}
 }
 
+func TestFilters_SingleSubscription_ExcludeRemoved(t *testing.T) {
+	t.Parallel()
+	config := FiltersConfig{}
+	f := New(context.TODO(), config, nil, nil, nil, func() {}, log.New())
+
+	exclude := false
+	outChan, _ := f.SubscribeLogs(10, filters.FilterCriteria{FilterIncludeRemoved: &exclude})
+	allChan, _ := f.SubscribeLogs(10, filters.FilterCriteria{})
+
+	log := createLog()
+	log.Removed = true
+	f.OnNewLogs(log)
+
+	if len(outChan) != 0 {
+		t.Error("expected the removed log to be dropped for the subscription excluding removed logs")
+	}
+	if len(allChan) != 1 {
+		t.Error("expected the removed log to reach the subscription including removed logs")
+	}
+
+	log.Removed = false
+	f.OnNewLogs(log)
+
+	if len(outChan) != 1 {
+		t.Error("expected a canonical log in the channel")
+	}
+}
+
 func TestFilters_TwoSubscriptionsWithDifferentCriteria(t *testing.T) {
 	t.Parallel()
 	config := FiltersConfig{}
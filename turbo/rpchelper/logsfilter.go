@@ -44,6 +44,7 @@ type LogsFilter struct {
 	allTopics      int
 	topics         *concurrent.SyncMap[libcommon.Hash, int]
 	topicsOriginal [][]libcommon.Hash // Original topic filters to be applied before distributing to individual subscribers
+	excludeRemoved bool               // Drop logs reverted by a chain reorganisation before distributing to the subscriber
 	sender         Sub[*types2.Log]   // nil for aggregate subscriber, for appropriate stream server otherwise
 }
 
@@ -224,6 +225,9 @@ func (a *LogsFilterAggregator) distributeLog(eventLog *remote.SubscribeLogsReply
 	var topics []libcommon.Hash
 
 	a.logsFilters.Range(func(k LogsSubID, filter *LogsFilter) error {
+		if eventLog.Removed && filter.excludeRemoved {
+			return nil
+		}
 		if filter.allAddrs == 0 {
 			_, addrOk := filter.addrs.Get(gointerfaces.ConvertH160toAddress(eventLog.Address))
 			if !addrOk {